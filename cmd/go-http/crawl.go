@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/handlers"
+	"github.com/apoldev/go-http/internal/pkg/app"
+	"github.com/apoldev/go-http/pkg/logger"
+)
+
+const (
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// crawlLine is a line of the NDJSON output.
+type crawlLine struct {
+	URL string `json:"url"`
+	handlers.CrawlURLResult
+}
+
+// crawlCmd crawls the URLs like the server does for a request, with the same
+// limits, and prints the results in the format of the detailed response.
+func crawlCmd(args []string, e env) error {
+	fs, loader := newFlagSet("crawl", e, true)
+	input := fs.String("input", "", `file with a URL per line, "-" for stdin; stdin when there are no URL arguments`)
+	output := fs.String("output", outputJSON, "output format: json, an object by URL, or ndjson, a line per URL")
+	deadline := fs.Duration("deadline", 0, "deadline of the whole crawl, e.g. 5s; capped by CRAWLER_MAX_BATCH_TIMEOUT_MS")
+	debug := fs.Bool("debug", false, "add the timings of every fetch")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-http crawl [flags] [url ...]")
+		fs.PrintDefaults()
+	}
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *output != outputJSON && *output != outputNDJSON {
+		return fmt.Errorf("invalid output %q, want %s or %s", *output, outputJSON, outputNDJSON)
+	}
+
+	cfg, err := loader.Load(e.lookupEnv)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	if loader.PrintConfig() {
+		return cfg.Print(e.stdout)
+	}
+
+	urls := fs.Args()
+	if *input != "" || len(urls) == 0 {
+		fromInput, err := readURLs(*input, e.stdin)
+		if err != nil {
+			return err
+		}
+		urls = append(urls, fromInput...)
+	}
+	if len(urls) == 0 {
+		return errors.New("no urls")
+	}
+	if len(urls) > cfg.Crawler.MaxURLs {
+		return fmt.Errorf("too many urls: %d, max is %d", len(urls), cfg.Crawler.MaxURLs)
+	}
+
+	// logs go to stderr, so that stdout has nothing but the results
+	log, err := logger.New(e.stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+	service := app.NewCrawler(cfg, log)
+
+	batch := crawler.Batch{
+		Targets: make([]crawler.Target, len(urls)),
+		Timings: *debug,
+	}
+	for i, u := range urls {
+		batch.Targets[i] = crawler.Target{URL: u}
+	}
+	batch.Deadline = *deadline
+	if limit := cfg.Crawler.MaxBatchTimeout.Std(); limit > 0 && batch.Deadline > limit {
+		batch.Deadline = limit
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	results, err := service.CrawlBatch(ctx, batch)
+	if err != nil {
+		return err
+	}
+	log.Debug("crawl finished", slog.Duration(logger.KeyDuration, time.Since(start)), slog.Int("urls", len(urls)))
+
+	return writeResults(e.stdout, *output, urls, results)
+}
+
+// readURLs reads a URL per line from the file, or from stdin when the file is
+// "-" or empty. Blank lines and lines starting with # are skipped.
+func readURLs(file string, stdin io.Reader) ([]string, error) {
+	r := stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+func writeResults(w io.Writer, output string, urls []string, results map[string]crawler.Result) error {
+	enc := json.NewEncoder(w)
+	// the bodies are read by people, mostly HTML
+	enc.SetEscapeHTML(false)
+	if output == outputJSON {
+		resp := make(handlers.CrawlDetailedResponse, len(results))
+		for u, res := range results {
+			resp[u] = handlers.NewCrawlURLResult(res)
+		}
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	// in the order of the input
+	for _, u := range urls {
+		if err := enc.Encode(crawlLine{URL: u, CrawlURLResult: handlers.NewCrawlURLResult(results[u])}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
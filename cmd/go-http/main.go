@@ -1,19 +1,119 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 
-	"github.com/apoldev/go-http/internal/pkg/app"
+	"github.com/apoldev/go-http/internal/pkg/config"
 )
 
+const usage = `Usage: go-http [command] [flags]
+
+Commands:
+  serve            run the server, the default command
+  crawl            crawl URLs from the arguments, a file or stdin and print the results
+  validate-config  check the configuration and exit
+  version          print the build information
+
+Run 'go-http <command> -h' for the flags of a command. Every setting has a flag
+named after its env variable, e.g. --server-max-connections.
+`
+
+// env is what a command gets from the process, replaced in tests.
+type env struct {
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	lookupEnv func(key string) (string, bool)
+}
+
 func main() {
-	a, err := app.New()
-	if err != nil {
-		log.Fatal(err)
+	os.Exit(run(os.Args[1:], env{
+		stdin:     os.Stdin,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		lookupEnv: os.LookupEnv,
+	}))
+}
+
+// run runs the command of args and returns the exit code.
+func run(args []string, e env) int {
+	cmd := "serve"
+	// flags without a command are the flags of serve, as before there were commands
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = serveCmd(args, e)
+	case "crawl":
+		err = crawlCmd(args, e)
+	case "validate-config":
+		err = validateConfigCmd(args, e)
+	case "version":
+		err = versionCmd(args, e)
+	case "help":
+		fmt.Fprint(e.stdout, usage)
+	default:
+		fmt.Fprintf(e.stderr, "unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		// the flag set has already printed the error and the usage
+		return 2
+	default:
+		fmt.Fprintf(e.stderr, "go-http %s: %v\n", cmd, err)
+		return 1
+	}
+}
+
+// errUsage is returned on malformed flags and arguments.
+var errUsage = errors.New("usage")
+
+// newFlagSet creates the flags of a command, with the configuration flags when
+// the command loads the configuration.
+func newFlagSet(cmd string, e env, withConfig bool) (*flag.FlagSet, *config.Loader) {
+	fs := flag.NewFlagSet("go-http "+cmd, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	if !withConfig {
+		return fs, nil
 	}
+	return fs, config.NewLoader(fs)
+}
 
-	err = a.Run()
+// parse parses the flags, a malformed flag is reported as errUsage.
+func parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+func validateConfigCmd(args []string, e env) error {
+	fs, loader := newFlagSet("validate-config", e, true)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	cfg, err := loader.Load(e.lookupEnv)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	if loader.PrintConfig() {
+		return cfg.Print(e.stdout)
 	}
+	fmt.Fprintln(e.stdout, "configuration is valid")
+	return nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func runCmd(t *testing.T, stdin string, env map[string]string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, newEnv(stdin, &stdout, &stderr, env))
+	return code, stdout.String(), stderr.String()
+}
+
+func newEnv(stdin string, stdout, stderr io.Writer, vars map[string]string) env {
+	return env{
+		stdin:  strings.NewReader(stdin),
+		stdout: stdout,
+		stderr: stderr,
+		lookupEnv: func(key string) (string, bool) {
+			v, ok := vars[key]
+			return v, ok
+		},
+	}
+}
+
+func newUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<p>" + r.URL.Path + "</p>"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCrawl(t *testing.T) {
+	srv := newUpstream(t)
+
+	t.Run("ndjson", func(t *testing.T) {
+		code, stdout, stderr := runCmd(t, "", nil, "crawl", "--output", "ndjson", srv.URL+"/b", srv.URL+"/a")
+		require.Equal(t, 0, code, stderr)
+
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Equal(t, []string{
+			`{"url":"` + srv.URL + `/b","status":"ok","body":"<p>/b</p>"}`,
+			`{"url":"` + srv.URL + `/a","status":"ok","body":"<p>/a</p>"}`,
+		}, lines)
+	})
+
+	t.Run("json_from_stdin", func(t *testing.T) {
+		stdin := srv.URL + "/a\n\n# skipped\n" + srv.URL + "/b\n"
+		code, stdout, stderr := runCmd(t, stdin, nil, "crawl")
+		require.Equal(t, 0, code, stderr)
+
+		var resp map[string]map[string]string
+		require.NoError(t, json.Unmarshal([]byte(stdout), &resp))
+		require.Len(t, resp, 2)
+		require.Equal(t, "<p>/b</p>", resp[srv.URL+"/b"]["body"])
+	})
+
+	t.Run("too_many_urls", func(t *testing.T) {
+		code, _, stderr := runCmd(t, "", map[string]string{"CRAWLER_MAX_URLS": "1"},
+			"crawl", srv.URL+"/a", srv.URL+"/b")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "too many urls: 2, max is 1")
+	})
+
+	t.Run("blocked_host", func(t *testing.T) {
+		code, stdout, stderr := runCmd(t, "", nil, "crawl", "--crawler-blocked-hosts", "127.0.0.1", srv.URL+"/a")
+		require.Equal(t, 1, code)
+		require.Empty(t, stdout)
+		require.Contains(t, stderr, "host not allowed")
+	})
+}
+
+func TestValidateConfig(t *testing.T) {
+	code, stdout, _ := runCmd(t, "", nil, "validate-config")
+	require.Equal(t, 0, code)
+	require.Equal(t, "configuration is valid\n", stdout)
+
+	code, _, stderr := runCmd(t, "", map[string]string{"SERVER_MAX_CONNECTIONS": "1OO"}, "validate-config")
+	require.Equal(t, 1, code)
+	require.Contains(t, stderr, `SERVER_MAX_CONNECTIONS: invalid integer "1OO"`)
+}
+
+func TestRun_Usage(t *testing.T) {
+	code, stdout, _ := runCmd(t, "", nil, "version")
+	require.Equal(t, 0, code)
+	require.True(t, strings.HasPrefix(stdout, "go-http "))
+	require.Contains(t, stdout, "\ngo: go")
+
+	code, _, stderr := runCmd(t, "", nil, "deploy")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, `unknown command "deploy"`)
+
+	code, _, _ = runCmd(t, "", nil, "crawl", "--no-such-flag")
+	require.Equal(t, 2, code)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/apoldev/go-http/internal/pkg/app"
+	"github.com/apoldev/go-http/internal/pkg/config"
+)
+
+// serveCmd runs the server until it is stopped by a signal.
+func serveCmd(args []string, e env) error {
+	fs, loader := newFlagSet("serve", e, true)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loader.Load(e.lookupEnv)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	if loader.PrintConfig() {
+		return cfg.Print(e.stdout)
+	}
+
+	a, err := app.New(cfg, func() (*config.Config, error) {
+		return loader.Load(e.lookupEnv)
+	})
+	if err != nil {
+		return err
+	}
+	return a.Run()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD)" ./cmd/go-http
+//
+// Unset values are taken from the build information Go embeds in the binary.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildInfo is the build metadata of the binary.
+type buildInfo struct {
+	Version   string
+	Commit    string
+	Date      string
+	Modified  bool
+	GoVersion string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, Date: date}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.GoVersion = bi.GoVersion
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func versionCmd(args []string, e env) error {
+	fs, _ := newFlagSet("version", e, false)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	info := readBuildInfo()
+	commit := orUnknown(info.Commit)
+	if info.Modified {
+		commit += " (modified)"
+	}
+	fmt.Fprintf(e.stdout, "go-http %s\ncommit: %s\nbuilt: %s\ngo: %s\n",
+		orUnknown(info.Version), commit, orUnknown(info.Date), orUnknown(info.GoVersion))
+	return nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
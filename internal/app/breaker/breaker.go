@@ -0,0 +1,291 @@
+package breaker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects requests.
+var ErrOpen = errors.New("circuit_open")
+
+type State int
+
+const (
+	// Closed lets all requests through and counts failures.
+	Closed State = iota
+	// Open rejects all requests until the cool-down has passed.
+	Open
+	// HalfOpen lets a few probe requests through to decide whether to close again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Config configures circuit breakers.
+type Config struct {
+	// FailureRate in (0, 1] opens the breaker once this share of requests in the window has failed.
+	FailureRate float64
+	// MinRequests is the number of requests in the window needed before the failure rate is checked.
+	MinRequests int
+	// Window is the period the counters are collected over. Counters are reset when it ends.
+	Window time.Duration
+	// CoolDown is how long the breaker stays open before letting probes through.
+	CoolDown time.Duration
+	// HalfOpenRequests is the number of successful probes needed to close the breaker.
+	HalfOpenRequests int
+}
+
+// Breaker is a circuit breaker for a single upstream.
+type Breaker struct {
+	cfg Config
+
+	mu          sync.Mutex
+	state       State
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int
+	successes   int
+	// inFlight and lastUsed tell whether the breaker can be dropped by its Set.
+	inFlight int
+	lastUsed time.Time
+}
+
+func New(cfg Config) *Breaker {
+	if cfg.HalfOpenRequests < 1 {
+		cfg.HalfOpenRequests = 1
+	}
+	now := time.Now()
+	return &Breaker{
+		cfg:         cfg,
+		windowStart: now,
+		lastUsed:    now,
+	}
+}
+
+// Allow reports whether a request may be sent. Every allowed request must be
+// followed by exactly one call to Done or Cancel.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case Open:
+		if now.Sub(b.openedAt) < b.cfg.CoolDown {
+			return ErrOpen
+		}
+		b.setState(HalfOpen, now)
+		fallthrough
+	case HalfOpen:
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return ErrOpen
+		}
+		b.probes++
+	case Closed:
+		if now.Sub(b.windowStart) >= b.cfg.Window {
+			b.resetCounts(now)
+		}
+	}
+
+	b.requests++
+	b.inFlight++
+	b.lastUsed = now
+	return nil
+}
+
+// Done records the outcome of an allowed request.
+func (b *Breaker) Done(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight--
+	now := time.Now()
+	switch b.state {
+	case HalfOpen:
+		b.releaseProbe()
+		if !success {
+			b.setState(Open, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.cfg.HalfOpenRequests {
+			b.setState(Closed, now)
+		}
+	case Closed:
+		if success {
+			return
+		}
+		b.failures++
+		if b.requests >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.FailureRate*float64(b.requests) {
+			b.setState(Open, now)
+		}
+	case Open:
+		// a request allowed before the breaker opened
+	}
+}
+
+// Cancel releases an allowed request without recording its outcome, e.g.
+// when the caller has gone away and the upstream's health is unknown.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight--
+	if b.state == HalfOpen {
+		b.releaseProbe()
+	}
+}
+
+// releaseProbe frees a half-open slot. A request allowed before the breaker
+// opened may finish during half-open, so the counter must not go negative.
+func (b *Breaker) releaseProbe() {
+	if b.probes > 0 {
+		b.probes--
+	}
+}
+
+// idle reports whether the breaker knows nothing worth keeping: it is closed,
+// has no requests in flight and its counters would be reset by the next request.
+func (b *Breaker) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == Closed && b.inFlight == 0 && now.Sub(b.lastUsed) >= b.cfg.Window
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) setState(state State, now time.Time) {
+	b.state = state
+	b.probes = 0
+	b.successes = 0
+	if state == Open {
+		b.openedAt = now
+	}
+	b.resetCounts(now)
+}
+
+func (b *Breaker) resetCounts(now time.Time) {
+	b.requests = 0
+	b.failures = 0
+	b.windowStart = now
+}
+
+// Status is a snapshot of a breaker state.
+type Status struct {
+	Host     string     `json:"host"`
+	State    State      `json:"state"`
+	Requests int        `json:"requests"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+func (b *Breaker) status(host string) Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st := Status{
+		Host:     host,
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+	}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		st.OpenedAt = &openedAt
+	}
+	return st
+}
+
+// Set keeps a breaker per upstream host. Idle breakers are dropped once per
+// window, so that crawling many hosts doesn't grow the set without bound; a
+// dropped breaker starts afresh, as it would have anyway.
+type Set struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	breakers  map[string]*Breaker
+	lastSweep time.Time
+}
+
+func NewSet(cfg Config) *Set {
+	return &Set{
+		cfg:       cfg,
+		breakers:  make(map[string]*Breaker),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the breaker of the host, creating it on first use.
+func (s *Set) Get(host string) *Breaker {
+	s.mu.RLock()
+	b, ok := s.breakers[host]
+	s.mu.RUnlock()
+	if ok {
+		return b
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok = s.breakers[host]; ok {
+		return b
+	}
+	s.evictIdle(time.Now())
+	b = New(s.cfg)
+	s.breakers[host] = b
+	return b
+}
+
+// Len returns the number of tracked hosts.
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.breakers)
+}
+
+func (s *Set) evictIdle(now time.Time) {
+	if now.Sub(s.lastSweep) < s.cfg.Window {
+		return
+	}
+	s.lastSweep = now
+	for host, b := range s.breakers {
+		if b.idle(now) {
+			delete(s.breakers, host)
+		}
+	}
+}
+
+// Statuses returns the state of every known breaker sorted by host.
+func (s *Set) Statuses() []Status {
+	s.mu.RLock()
+	statuses := make([]Status, 0, len(s.breakers))
+	for host, b := range s.breakers {
+		statuses = append(statuses, b.status(host))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Host < statuses[j].Host })
+	return statuses
+}
@@ -0,0 +1,129 @@
+package breaker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Parallel()
+
+	cfg := breaker.Config{
+		FailureRate:      0.5,
+		MinRequests:      4,
+		Window:           time.Minute,
+		CoolDown:         50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	}
+
+	cases := []struct {
+		name          string
+		outcomes      []bool
+		expectedState breaker.State
+	}{
+		{
+			name:          "below_min_requests",
+			outcomes:      []bool{false, false, false},
+			expectedState: breaker.Closed,
+		},
+		{
+			name:          "below_failure_rate",
+			outcomes:      []bool{true, true, true, false},
+			expectedState: breaker.Closed,
+		},
+		{
+			name:          "opens",
+			outcomes:      []bool{true, false, true, false},
+			expectedState: breaker.Open,
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			b := breaker.New(cfg)
+			for _, ok := range c.outcomes {
+				require.NoError(t, b.Allow())
+				b.Done(ok)
+			}
+			require.Equal(t, c.expectedState, b.State())
+		})
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	t.Parallel()
+
+	b := breaker.New(breaker.Config{
+		FailureRate:      1,
+		MinRequests:      1,
+		Window:           time.Minute,
+		CoolDown:         50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	})
+
+	require.NoError(t, b.Allow())
+	b.Done(false)
+	require.ErrorIs(t, b.Allow(), breaker.ErrOpen)
+
+	// a failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, b.Allow())
+	require.Equal(t, breaker.HalfOpen, b.State())
+	require.ErrorIs(t, b.Allow(), breaker.ErrOpen, "only one probe at a time")
+	b.Done(false)
+	require.Equal(t, breaker.Open, b.State())
+
+	// a successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, b.Allow())
+	b.Done(true)
+	require.Equal(t, breaker.Closed, b.State())
+	require.NoError(t, b.Allow())
+}
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	s := breaker.NewSet(breaker.Config{FailureRate: 1, MinRequests: 1, Window: time.Minute, CoolDown: time.Minute})
+	require.Same(t, s.Get("a.com"), s.Get("a.com"))
+
+	require.NoError(t, s.Get("b.com").Allow())
+	s.Get("b.com").Done(false)
+
+	statuses := s.Statuses()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "a.com", statuses[0].Host)
+	require.Equal(t, breaker.Closed, statuses[0].State)
+	require.Equal(t, "b.com", statuses[1].Host)
+	require.Equal(t, breaker.Open, statuses[1].State)
+	require.NotNil(t, statuses[1].OpenedAt)
+}
+
+func TestSet_EvictIdle(t *testing.T) {
+	t.Parallel()
+
+	s := breaker.NewSet(breaker.Config{
+		FailureRate: 1, MinRequests: 1, Window: 20 * time.Millisecond, CoolDown: time.Minute,
+	})
+	idle := s.Get("idle.com")
+	require.NoError(t, idle.Allow())
+	idle.Done(true)
+	busy := s.Get("busy.com")
+	require.NoError(t, busy.Allow())
+	open := s.Get("open.com")
+	require.NoError(t, open.Allow())
+	open.Done(false)
+
+	time.Sleep(30 * time.Millisecond)
+	s.Get("new.com")
+	require.Equal(t, 3, s.Len())
+	require.NotSame(t, idle, s.Get("idle.com"))
+	require.Same(t, busy, s.Get("busy.com"), "a request in flight must report to its breaker")
+	require.Same(t, open, s.Get("open.com"), "an open breaker must keep rejecting")
+}
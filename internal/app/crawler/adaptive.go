@@ -0,0 +1,227 @@
+package crawler
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// aimdBackoff is the multiplicative decrease applied on congestion.
+const aimdBackoff = 0.9
+
+// ConcurrencyConfig configures adaptive outbound concurrency. Parallelism of a
+// single batch and in-flight fetches per upstream host are adjusted with AIMD:
+// they grow additively while fetches succeed faster than LatencyTarget and
+// shrink multiplicatively on errors and slow responses.
+type ConcurrencyConfig struct {
+	// Initial is the starting number of workers per batch.
+	Initial int
+	// Min and Max bound the number of workers per batch.
+	Min int
+	Max int
+	// HostMin and HostMax bound in-flight fetches per host across all batches.
+	HostMin int
+	HostMax int
+	// LatencyTarget is the latency above which a response is treated as congestion.
+	LatencyTarget time.Duration
+}
+
+// Enabled reports whether the config turns adaptive concurrency on.
+func (c ConcurrencyConfig) Enabled() bool {
+	return c.Max > 0 && c.HostMax > 0
+}
+
+// aimd is an additive increase, multiplicative decrease limit.
+type aimd struct {
+	min, max      float64
+	latencyTarget time.Duration
+
+	mu           sync.Mutex
+	limit        float64
+	lastDecrease time.Time
+}
+
+func newAIMD(initial, minLimit, maxLimit int, latencyTarget time.Duration) *aimd {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	return &aimd{
+		min:           float64(minLimit),
+		max:           float64(maxLimit),
+		latencyTarget: latencyTarget,
+		limit:         float64(min(max(initial, minLimit), maxLimit)),
+	}
+}
+
+func (a *aimd) Limit() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int(a.limit)
+}
+
+// reset sets the limit to n within the bounds.
+func (a *aimd) reset(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limit = math.Min(math.Max(float64(n), a.min), a.max)
+}
+
+// Observe adjusts the limit by the outcome of a single fetch.
+func (a *aimd) Observe(latency time.Duration, failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !failed && (a.latencyTarget <= 0 || latency <= a.latencyTarget) {
+		// grows by about one per limit completed fetches
+		a.limit = math.Min(a.limit+1/a.limit, a.max)
+		return
+	}
+
+	// fetches started before a decrease report the same congestion, so back off once per latency target
+	now := time.Now()
+	if now.Sub(a.lastDecrease) < a.latencyTarget {
+		return
+	}
+	a.lastDecrease = now
+	a.limit = math.Max(a.limit*aimdBackoff, a.min)
+}
+
+// slots caps the number of holders at a limit read on every acquire, so that
+// a changed limit applies to the waiters at once.
+type slots struct {
+	limit func() int
+
+	mu       sync.Mutex
+	inflight int
+	// changed is closed and replaced on every release to wake up waiters.
+	changed chan struct{}
+}
+
+func newSlots(limit func() int) *slots {
+	return &slots{limit: limit, changed: make(chan struct{})}
+}
+
+func (s *slots) acquire(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.inflight < s.limit() {
+			s.inflight++
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *slots) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight--
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *slots) inUse() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inflight
+}
+
+// hostIdleTTL is how long a host without fetches keeps its limit. A host seen
+// again after that starts wide open, as a new one.
+const hostIdleTTL = 10 * time.Minute
+
+// hostLimit caps in-flight fetches to a single host.
+type hostLimit struct {
+	*slots
+	aimd *aimd
+	// lastUsed is guarded by the mutex of adaptiveConcurrency.
+	lastUsed time.Time
+}
+
+type adaptiveConcurrency struct {
+	cfg    ConcurrencyConfig
+	global *aimd
+
+	mu        sync.Mutex
+	hosts     map[string]*hostLimit
+	lastSweep time.Time
+}
+
+func newAdaptiveConcurrency(cfg ConcurrencyConfig) *adaptiveConcurrency {
+	return &adaptiveConcurrency{
+		cfg:       cfg,
+		global:    newAIMD(cfg.Initial, cfg.Min, cfg.Max, cfg.LatencyTarget),
+		hosts:     make(map[string]*hostLimit),
+		lastSweep: time.Now(),
+	}
+}
+
+// workers returns the current number of workers for a batch of n targets.
+func (a *adaptiveConcurrency) workers(n int) int {
+	return max(min(a.global.Limit(), n), 1)
+}
+
+// maxWorkers returns the most workers a batch of n targets may get.
+func (a *adaptiveConcurrency) maxWorkers(n int) int {
+	return max(min(int(a.global.max), n), 1)
+}
+
+// acquire waits for a fetch slot of the host. The returned func releases the
+// slot and feeds the fetch outcome back to the controller.
+func (a *adaptiveConcurrency) acquire(
+	ctx context.Context, host string,
+) (func(latency time.Duration, failed, cancelled bool), error) {
+	h := a.host(host)
+	if err := h.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	return func(latency time.Duration, failed, cancelled bool) {
+		h.release()
+		if cancelled {
+			return
+		}
+		h.aimd.Observe(latency, failed)
+		a.global.Observe(latency, failed)
+	}, nil
+}
+
+func (a *adaptiveConcurrency) host(host string) *hostLimit {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	h, ok := a.hosts[host]
+	if !ok {
+		a.evictIdle(now)
+		// hosts start wide open and back off once they struggle
+		limit := newAIMD(a.cfg.HostMax, a.cfg.HostMin, a.cfg.HostMax, a.cfg.LatencyTarget)
+		h = &hostLimit{slots: newSlots(limit.Limit), aimd: limit}
+		a.hosts[host] = h
+	}
+	h.lastUsed = now
+	return h
+}
+
+// evictIdle forgets the hosts without fetches for hostIdleTTL, so that
+// crawling many hosts doesn't grow the map without bound. It runs at most once
+// per hostIdleTTL.
+func (a *adaptiveConcurrency) evictIdle(now time.Time) {
+	if now.Sub(a.lastSweep) < hostIdleTTL {
+		return
+	}
+	a.lastSweep = now
+	for host, h := range a.hosts {
+		if now.Sub(h.lastUsed) >= hostIdleTTL && h.inUse() == 0 {
+			delete(a.hosts, host)
+		}
+	}
+}
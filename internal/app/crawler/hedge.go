@@ -0,0 +1,181 @@
+package crawler
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeWindow is the number of recent latencies used to compute the hedge percentile.
+	hedgeWindow = 512
+	// hedgeMinSamples is the number of latencies needed before the percentile replaces the fixed delay.
+	hedgeMinSamples = 20
+	// hedgeRecalcEvery is how often, in observations, the percentile is recomputed.
+	hedgeRecalcEvery = 16
+	// hedgeMaxTokens caps the hedge budget, so that a quiet period can't be followed by a hedge storm.
+	hedgeMaxTokens = 10
+)
+
+// HedgeConfig configures hedged requests. A hedge is a second identical GET
+// fired when the first one has not answered within the hedge delay.
+type HedgeConfig struct {
+	// Delay is a fixed delay before the hedge is sent. With Percentile set it is
+	// used only until enough latencies have been observed; zero disables it.
+	Delay time.Duration
+	// Percentile, in (0, 100), hedges after the observed latency percentile,
+	// e.g. 95 sends a hedge for requests slower than the recent p95.
+	Percentile float64
+	// BudgetPercent caps the number of hedges as a percent of primary requests.
+	BudgetPercent float64
+}
+
+// Enabled reports whether the config turns hedging on.
+func (c HedgeConfig) Enabled() bool {
+	return (c.Delay > 0 || c.Percentile > 0) && c.BudgetPercent > 0
+}
+
+type hedger struct {
+	cfg HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+	observed  int
+	threshold time.Duration
+	tokens    float64
+}
+
+func newHedger(cfg HedgeConfig) *hedger {
+	return &hedger{
+		cfg:       cfg,
+		latencies: make([]time.Duration, 0, hedgeWindow),
+	}
+}
+
+type hedgeResult struct {
+	data  []byte
+	err   error
+	hedge bool
+}
+
+// Do calls fn and, if it has not returned within the hedge delay and the budget
+// allows, calls it once more concurrently with hedge set. The first successful
+// answer wins and the other call is cancelled. fn must be idempotent.
+func (h *hedger) Do(
+	ctx context.Context, fn func(ctx context.Context, hedge bool) ([]byte, error),
+) ([]byte, bool, error) {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	launch := func(hedge bool) {
+		go func() {
+			data, err := fn(ctx, hedge)
+			results <- hedgeResult{data: data, err: err, hedge: hedge}
+		}()
+	}
+
+	start := time.Now()
+	launch(false)
+	h.deposit()
+	inflight := 1
+	hedged := false
+	var primaryErr error
+
+	var timerC <-chan time.Time
+	if delay, ok := h.delay(); ok {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	for {
+		select {
+		case <-timerC:
+			if h.withdraw() {
+				launch(true)
+				inflight++
+				hedged = true
+			}
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				h.observe(time.Since(start))
+				return res.data, hedged, nil
+			}
+			if !res.hedge {
+				primaryErr = res.err
+			}
+			// hedging is not a retry: a failed primary is returned unless a
+			// hedge is still running, and it is the error of the fetch
+			if inflight == 0 {
+				return nil, hedged, primaryErr
+			}
+		}
+	}
+}
+
+// delay returns the time to wait before hedging, false means no hedge.
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.cfg.Percentile > 0 && h.threshold > 0 {
+		return h.threshold, true
+	}
+	return h.cfg.Delay, h.cfg.Delay > 0
+}
+
+func (h *hedger) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgeWindow {
+		h.latencies = append(h.latencies, d)
+	} else {
+		h.latencies[h.next] = d
+		h.next = (h.next + 1) % hedgeWindow
+	}
+	h.observed++
+
+	if h.cfg.Percentile > 0 && len(h.latencies) >= hedgeMinSamples && h.observed%hedgeRecalcEvery == 0 {
+		h.threshold = percentile(h.latencies, h.cfg.Percentile)
+	}
+}
+
+// deposit credits the budget for one primary request.
+func (h *hedger) deposit() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens = math.Min(h.tokens+h.cfg.BudgetPercent/100, hedgeMaxTokens)
+}
+
+// withdraw takes one hedge from the budget.
+func (h *hedger) withdraw() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
@@ -0,0 +1,59 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrHostNotAllowed is returned for targets whose host is blocked or not allowed.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// HostPolicy decides which hosts may be crawled. A host matches an entry
+// when it equals the entry or is its subdomain. Blocked hosts take precedence,
+// an empty Allowed list allows every host that isn't blocked.
+type HostPolicy struct {
+	Allowed []string
+	Blocked []string
+}
+
+// Allows reports whether host, without a port, may be crawled.
+func (p *HostPolicy) Allows(host string) bool {
+	if p == nil {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHost(host, p.Blocked) {
+		return false
+	}
+	return len(p.Allowed) == 0 || matchHost(host, p.Allowed)
+}
+
+// check fails with ErrHostNotAllowed on the first target that may not be crawled.
+func (p *HostPolicy) check(targets []Target) error {
+	if p == nil {
+		return nil
+	}
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			// fails on fetch like before
+			continue
+		}
+		if !p.Allows(u.Hostname()) {
+			return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+		}
+	}
+	return nil
+}
+
+func matchHost(host string, list []string) bool {
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSuffix(entry, "."))
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/metrics"
+)
+
+// Fetch outcomes used as metric labels.
+const (
+	OutcomeOK           = "ok"
+	OutcomeError        = "error"
+	OutcomeTimeout      = "timeout"
+	OutcomeCancelled    = "cancelled"
+	OutcomeBreakerOpen  = "breaker_open"
+	OutcomeOutboundBusy = "outbound_busy"
+)
+
+// HostOther is the host label shared by the hosts past the first maxHostLabels.
+const HostOther = "other"
+
+// maxHostLabels bounds the series of the host label: the hosts come from the
+// clients, so only the first hosts seen get a label of their own.
+const maxHostLabels = 100
+
+// Metrics instruments the fetches of a Service.
+type Metrics struct {
+	fetchDuration *metrics.HistogramVec
+	fetchedBytes  *metrics.Counter
+	activeWorkers *metrics.Gauge
+
+	mu    sync.Mutex
+	hosts map[string]struct{}
+}
+
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	return &Metrics{
+		fetchDuration: reg.NewHistogramVec("crawler_fetch_duration_seconds",
+			"Duration of fetching a single URL, by host and outcome.", nil, "host", "outcome"),
+		fetchedBytes:  reg.NewCounter("crawler_fetched_bytes_total", "Bytes of response bodies fetched."),
+		activeWorkers: reg.NewGauge("crawler_active_workers", "Number of workers fetching a URL right now."),
+		hosts:         make(map[string]struct{}),
+	}
+}
+
+// WithMetrics makes the service report its fetches.
+func WithMetrics(m *Metrics) Option {
+	return func(s *Service) {
+		s.metrics = m
+	}
+}
+
+func (m *Metrics) fetchStarted() {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Inc()
+}
+
+// fetchDone reports a finished fetch of host.
+func (m *Metrics) fetchDone(ctx context.Context, host string, latency time.Duration, n int, err error) {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Dec()
+	m.fetchDuration.With(m.hostLabel(host), fetchOutcome(ctx, err)).Observe(latency.Seconds())
+	m.fetchedBytes.Add(float64(n))
+}
+
+// hostLabel returns host while there are fewer than maxHostLabels hosts, and
+// HostOther for the hosts seen after that.
+func (m *Metrics) hostLabel(host string) string {
+	host = strings.ToLower(host)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.hosts[host]; ok {
+		return host
+	}
+	if len(m.hosts) >= maxHostLabels {
+		return HostOther
+	}
+	m.hosts[host] = struct{}{}
+	return host
+}
+
+// fetchOutcome classifies the result of a fetch; ctx is the batch context.
+func fetchOutcome(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case ctx.Err() != nil:
+		return OutcomeCancelled
+	case errors.Is(err, breaker.ErrOpen):
+		return OutcomeBreakerOpen
+	case errors.Is(err, ErrOutboundBusy):
+		return OutcomeOutboundBusy
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	default:
+		return OutcomeError
+	}
+}
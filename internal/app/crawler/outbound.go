@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+// ErrOutboundBusy is returned for targets that did not get an outbound slot in time.
+var ErrOutboundBusy = errors.New("outbound limit reached")
+
+// OutboundConfig configures the process-wide outbound limit.
+type OutboundConfig struct {
+	// Limit is the max number of outbound requests in flight.
+	Limit int
+	// QueueSize is the max number of requests waiting for a slot.
+	QueueSize int
+	// MaxWait is how long a request waits for a slot.
+	MaxWait time.Duration
+}
+
+// Outbound caps the number of outbound HTTP requests of the whole process,
+// whatever the number of incoming requests, batches and workers. Every request
+// sent upstream, hedges included, holds a slot until its body has been read.
+// It limits requests, not connections: the idle connections kept alive by the
+// transport hold no slot.
+type Outbound struct {
+	limit   int
+	slots   *limiter.QueueLimiter
+	waiting atomic.Int64
+
+	acquired atomic.Uint64
+	rejected atomic.Uint64
+	waitTime atomic.Int64
+}
+
+func NewOutbound(cfg OutboundConfig) *Outbound {
+	return &Outbound{
+		limit: cfg.Limit,
+		slots: limiter.NewQueueLimiter(cfg.Limit, cfg.QueueSize, cfg.MaxWait),
+	}
+}
+
+// OutboundStats is a snapshot of the outbound limit.
+type OutboundStats struct {
+	Limit    int    `json:"limit"`
+	InUse    int    `json:"in_use"`
+	Waiting  int    `json:"waiting"`
+	Acquired uint64 `json:"acquired_total"`
+	Rejected uint64 `json:"rejected_total"`
+	// WaitSeconds is the total time spent waiting for slots.
+	WaitSeconds float64 `json:"wait_seconds_total"`
+}
+
+func (o *Outbound) Stats() OutboundStats {
+	st := o.slots.State()
+	return OutboundStats{
+		Limit:       o.limit,
+		InUse:       st.Limit - st.Remaining,
+		Waiting:     int(o.waiting.Load()),
+		Acquired:    o.acquired.Load(),
+		Rejected:    o.rejected.Load(),
+		WaitSeconds: time.Duration(o.waitTime.Load()).Seconds(),
+	}
+}
+
+// acquire waits for a slot. Errors wrap ErrOutboundBusy, including ctx ones:
+// a fetch that timed out in the queue has not reached its host.
+func (o *Outbound) acquire(ctx context.Context) error {
+	start := time.Now()
+	o.waiting.Add(1)
+	err := o.slots.Acquire(ctx)
+	o.waiting.Add(-1)
+	o.waitTime.Add(int64(time.Since(start)))
+
+	if err == nil {
+		o.acquired.Add(1)
+		return nil
+	}
+	if ctx.Err() == nil {
+		o.rejected.Add(1)
+	}
+	return fmt.Errorf("%w: %w", ErrOutboundBusy, err)
+}
+
+// tryAcquire takes a slot if one is free, without waiting.
+func (o *Outbound) tryAcquire() bool {
+	if !o.slots.Take() {
+		return false
+	}
+	o.acquired.Add(1)
+	return true
+}
+
+func (o *Outbound) release() {
+	o.slots.Release()
+}
@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned when a job is submitted to a closed pool.
+var ErrPoolClosed = errors.New("pool is closed")
+
+// Pool is a process-wide set of long-lived fetch workers shared by all batches.
+// Its size bounds the total number of fetches running at once; fairness between
+// batches is kept by the Service, which never has more than its worker count of
+// jobs in the pool per batch.
+type Pool struct {
+	jobs chan func()
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func NewPool(size int) *Pool {
+	p := &Pool{
+		jobs: make(chan func()),
+		done: make(chan struct{}),
+	}
+
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case job := <-p.jobs:
+			job()
+		}
+	}
+}
+
+// Submit waits for a free worker and hands it the job.
+func (p *Pool) Submit(ctx context.Context, job func()) error {
+	select {
+	case <-p.done:
+		return ErrPoolClosed
+	default:
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return ErrPoolClosed
+	}
+}
+
+// Close stops the workers once they have finished their current jobs.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+}
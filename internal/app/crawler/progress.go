@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Progress is the live progress of a batch, to be followed from another
+// goroutine. A batch reports to the Progress of its context, see WithProgress.
+type Progress struct {
+	mu        sync.Mutex
+	completed int
+	// fetching counts the fetches in flight by URL, a URL may be listed twice.
+	fetching map[string]int
+}
+
+func NewProgress() *Progress {
+	return &Progress{fetching: make(map[string]int)}
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context in which batches report to p.
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func progressFrom(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// Snapshot returns the number of URLs done, successfully or not, and the URLs
+// being fetched.
+func (p *Progress) Snapshot() (int, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fetching := make([]string, 0, len(p.fetching))
+	for u := range p.fetching {
+		fetching = append(fetching, u)
+	}
+	sort.Strings(fetching)
+	return p.completed, fetching
+}
+
+func (p *Progress) start(u string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetching[u]++
+}
+
+func (p *Progress) done(u string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fetching[u]--; p.fetching[u] <= 0 {
+		delete(p.fetching, u)
+	}
+	p.completed++
+}
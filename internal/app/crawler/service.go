@@ -2,76 +2,370 @@ package crawler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/apoldev/go-http/internal/app/tracing"
 	"github.com/apoldev/go-http/pkg/logger"
 )
 
+// ErrTimedOut marks targets that were not fetched before the batch deadline.
+var ErrTimedOut = errors.New("timed out")
+
+// maxRedirects is how many redirects a fetch follows, as http.Client does by default.
+const maxRedirects = 10
+
+// statusError marks a response with a server error status on its way to the
+// host's circuit breaker, which counts it as a failure. The body is still the
+// result of the fetch.
+type statusError struct {
+	code int
+	body []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("upstream status %d", e.code)
+}
+
 type Service struct {
-	workerCount    int
-	requestTimeout time.Duration
+	// workerCount, requestTimeout and hosts can be changed while crawling.
+	workerCount    atomic.Int64
+	requestTimeout atomic.Int64
+	hosts          atomic.Pointer[HostPolicy]
 	httpClient     *http.Client
-	logger         logger.Logger
+	logger         *slog.Logger
+	hedger         *hedger
+	breakers       *breaker.Set
+	concurrency    *adaptiveConcurrency
+	pool           *Pool
+	outbound       *Outbound
+	metrics        *Metrics
+	tracer         *tracing.Tracer
 }
 
-func New(workerCount, crawlerRequestTimeoutMs int, httpClient *http.Client, logger logger.Logger) *Service {
-	return &Service{
-		workerCount:    workerCount,
-		httpClient:     httpClient,
-		logger:         logger,
-		requestTimeout: time.Millisecond * time.Duration(crawlerRequestTimeoutMs),
+// Option configures optional Service features.
+type Option func(s *Service)
+
+// WithHedging enables hedged requests, see HedgeConfig.
+func WithHedging(cfg HedgeConfig) Option {
+	return func(s *Service) {
+		if cfg.Enabled() {
+			s.hedger = newHedger(cfg)
+		}
 	}
 }
 
-type resultCrawl struct {
-	Data []byte
+// WithBreakers makes the service consult a per-host circuit breaker before every
+// fetch and fail fast with breaker.ErrOpen while the host's breaker is open.
+func WithBreakers(breakers *breaker.Set) Option {
+	return func(s *Service) {
+		s.breakers = breakers
+	}
+}
+
+// WithAdaptiveConcurrency replaces the fixed worker count with the adaptive
+// controller, see ConcurrencyConfig.
+func WithAdaptiveConcurrency(cfg ConcurrencyConfig) Option {
+	return func(s *Service) {
+		if cfg.Enabled() {
+			s.concurrency = newAdaptiveConcurrency(cfg)
+		}
+	}
+}
+
+// WithPool runs fetches on the shared pool instead of spawning workers per batch.
+func WithPool(pool *Pool) Option {
+	return func(s *Service) {
+		s.pool = pool
+	}
+}
+
+// WithOutbound makes every upstream request take a slot of the process-wide outbound limit.
+func WithOutbound(outbound *Outbound) Option {
+	return func(s *Service) {
+		s.outbound = outbound
+	}
+}
+
+// WithHosts restricts the hosts that may be crawled, see HostPolicy.
+func WithHosts(policy HostPolicy) Option {
+	return func(s *Service) {
+		s.SetHosts(policy)
+	}
+}
+
+// WithTracer traces batches and fetches and propagates the trace to upstreams.
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(s *Service) {
+		s.tracer = tracer
+	}
+}
+
+func New(
+	workerCount, crawlerRequestTimeoutMs int, httpClient *http.Client, log *slog.Logger, opts ...Option,
+) *Service {
+	// a copy, so that checking the redirects doesn't change a shared client
+	client := *httpClient
+	s := &Service{
+		httpClient: &client,
+		logger:     log.With(logger.KeyComponent, "crawler"),
+	}
+	client.CheckRedirect = s.checkRedirect(httpClient.CheckRedirect)
+	s.workerCount.Store(int64(workerCount))
+	s.requestTimeout.Store(int64(time.Millisecond * time.Duration(crawlerRequestTimeoutMs)))
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetWorkerCount changes the number of workers of the batches started from now
+// on. With adaptive concurrency it resets the current limit of the controller.
+func (c *Service) SetWorkerCount(n int) {
+	c.workerCount.Store(int64(n))
+	if c.concurrency != nil {
+		c.concurrency.global.reset(n)
+	}
+}
+
+// SetRequestTimeout changes the timeout of the fetches of the batches started from now on.
+func (c *Service) SetRequestTimeout(d time.Duration) {
+	c.requestTimeout.Store(int64(d))
+}
+
+// SetHosts replaces the host policy of the batches started from now on. An
+// empty policy allows every host.
+func (c *Service) SetHosts(policy HostPolicy) {
+	if len(policy.Allowed) == 0 && len(policy.Blocked) == 0 {
+		c.hosts.Store(nil)
+		return
+	}
+	c.hosts.Store(&policy)
+}
+
+// Target is a single URL to fetch. Timeout overrides the service request timeout when set.
+type Target struct {
+	URL     string
+	Timeout time.Duration
+}
+
+// Batch is a set of targets crawled together. Deadline is the overall budget
+// for the whole batch; zero means the batch is bounded only by ctx.
+type Batch struct {
+	Targets  []Target
+	Deadline time.Duration
+	// Timings makes the results carry the timings of the fetches.
+	Timings bool
+}
+
+// Result is the outcome of fetching a single target.
+type Result struct {
 	URL  string
+	Data []byte
 	Err  error
+	// Timings is set for the fetches of batches that record timings, failed
+	// ones included: they have the phases reached before the failure.
+	Timings *Timings
 }
 
 // Crawl is a method for crawling multiple URLs.
 func (c *Service) Crawl(ctx context.Context, urls []string) (map[string][]byte, error) {
-	ch := make(chan string, len(urls))
-	resultCh := make(chan resultCrawl)
-	var wg sync.WaitGroup
+	batch := Batch{Targets: make([]Target, len(urls))}
+	for i := range urls {
+		batch.Targets[i] = Target{URL: urls[i]}
+	}
+
+	results, err := c.CrawlBatch(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, len(results))
+	for url, res := range results {
+		data[url] = res.Data
+	}
+	return data, nil
+}
+
+// CrawlBatch crawls all targets of the batch. The first failed target stops the
+// whole batch and its error is returned. When the batch deadline runs out, the
+// results fetched so far are returned and the rest are marked with ErrTimedOut.
+func (c *Service) CrawlBatch(ctx context.Context, batch Batch) (map[string]Result, error) {
+	ctx, span := c.tracer.Start(ctx, "crawl.batch", tracing.KindInternal,
+		slog.Int("urls", len(batch.Targets)),
+		slog.Duration("deadline", batch.Deadline),
+	)
+	defer span.End()
+
+	results, err := c.crawlBatch(ctx, batch)
+	span.SetError(err)
+	return results, err
+}
+
+func (c *Service) crawlBatch(ctx context.Context, batch Batch) (map[string]Result, error) {
+	if err := c.hosts.Load().check(batch.Targets); err != nil {
+		return nil, err
+	}
+
+	resultCh := make(chan Result)
 	var cancel context.CancelFunc
 
+	if batch.Timings {
+		ctx = context.WithValue(ctx, recordTimingsKey{}, true)
+	}
+	ctx = context.WithValue(ctx, requestTimeoutKey{}, time.Duration(c.requestTimeout.Load()))
+
 	ctx, cancel = context.WithCancel(ctx)
 	defer cancel()
 
-	wg.Add(c.workerCount)
-	for i := 0; i < c.workerCount; i++ {
-		go c.worker(ctx, ch, resultCh, &wg)
+	budgetCtx := ctx
+	if batch.Deadline > 0 {
+		var cancelBudget context.CancelFunc
+		budgetCtx, cancelBudget = context.WithTimeout(ctx, batch.Deadline)
+		defer cancelBudget()
+	}
+	budgetExpired := func() bool {
+		return ctx.Err() == nil && errors.Is(budgetCtx.Err(), context.DeadlineExceeded)
 	}
 
-	for i := range urls {
-		ch <- urls[i]
+	var fetching *slots
+	workerCount := int(c.workerCount.Load())
+	if c.concurrency != nil {
+		// enough workers for the most the batch may get, the current limit of
+		// the controller is checked before every fetch so that a long batch
+		// follows it
+		n := len(batch.Targets)
+		workerCount = c.concurrency.maxWorkers(n)
+		fetching = newSlots(func() int { return c.concurrency.workers(n) })
+	} else {
+		limit := workerCount
+		fetching = newSlots(func() int { return limit })
 	}
-	close(ch)
 
 	go func() {
-		wg.Wait()
-		c.logger.Print("all workers are finished")
+		if c.pool != nil {
+			c.runPooled(budgetCtx, ctx.Done(), batch.Targets, fetching, resultCh)
+		} else {
+			c.runWorkers(budgetCtx, ctx.Done(), batch.Targets, workerCount, fetching, resultCh)
+		}
+		c.logger.DebugContext(ctx, "all workers are finished")
 		close(resultCh)
 	}()
 
-	results := make(map[string][]byte)
+	results := make(map[string]Result, len(batch.Targets))
 	for res := range resultCh {
 		if res.Err != nil {
-			c.logger.Printf("got error at %s. Error: %v", res.URL, res.Err)
+			if budgetExpired() {
+				// cut short by the deadline, with the timings it has got to
+				results[res.URL] = Result{URL: res.URL, Err: ErrTimedOut, Timings: res.Timings}
+				continue
+			}
+			c.logger.LogAttrs(ctx, slog.LevelWarn, "batch failed",
+				slog.String(logger.KeyURL, res.URL),
+				logger.Err(res.Err),
+				slog.String(logger.KeyErrorClass, fetchOutcome(ctx, res.Err)),
+			)
 			return nil, res.Err
 		}
-		c.logger.Printf("got data from %s. Content-Length: %d", res.URL, len(res.Data))
-		results[res.URL] = res.Data
+		results[res.URL] = res
+	}
+	// the workers stop without a result once the caller has gone away or
+	// cancelled the batch, what is left must not pass for a complete batch
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if budgetExpired() {
+		c.logger.LogAttrs(ctx, slog.LevelInfo, "batch deadline exceeded",
+			slog.Duration("deadline", batch.Deadline),
+			slog.Int("finished", len(results)),
+			slog.Int("total", len(batch.Targets)),
+		)
+		for _, t := range batch.Targets {
+			if _, ok := results[t.URL]; !ok {
+				results[t.URL] = Result{URL: t.URL, Err: ErrTimedOut}
+			}
+		}
 	}
 
 	return results, nil
 }
 
-func (c *Service) worker(ctx context.Context, ch <-chan string, resultCh chan<- resultCrawl, wg *sync.WaitGroup) {
+// runWorkers fetches the targets with workerCount goroutines of its own, at
+// most as many at once as fetching allows.
+func (c *Service) runWorkers(
+	ctx context.Context, abort <-chan struct{}, targets []Target, workerCount int, fetching *slots,
+	resultCh chan<- Result,
+) {
+	ch := make(chan Target, len(targets))
+	for i := range targets {
+		ch <- targets[i]
+	}
+	close(ch)
+
+	var wg sync.WaitGroup
+	wg.Add(workerCount)
+	for i := 0; i < workerCount; i++ {
+		go c.worker(ctx, abort, ch, fetching, resultCh, &wg)
+	}
+	wg.Wait()
+}
+
+// runPooled fetches the targets on the shared pool, keeping at most as many of
+// them in the pool at once as fetching allows, so that a large batch can't
+// starve the others.
+func (c *Service) runPooled(
+	ctx context.Context, abort <-chan struct{}, targets []Target, fetching *slots, resultCh chan<- Result,
+) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for i := range targets {
+		if fetching.acquire(ctx) != nil {
+			return
+		}
+
+		target := targets[i]
+		wg.Add(1)
+		err := c.pool.Submit(ctx, func() {
+			defer wg.Done()
+			defer fetching.release()
+
+			res := c.fetchResult(ctx, target)
+			select {
+			case resultCh <- res:
+			case <-abort:
+			}
+		})
+		if err != nil {
+			wg.Done()
+			fetching.release()
+			if errors.Is(err, ErrPoolClosed) {
+				select {
+				case resultCh <- Result{URL: target.URL, Err: err}:
+				case <-abort:
+				}
+			}
+			return
+		}
+	}
+}
+
+// worker fetches targets until ch is drained or ctx is done. abort is closed
+// once the batch reader has gone away and no more results are consumed.
+func (c *Service) worker(
+	ctx context.Context, abort <-chan struct{}, ch <-chan Target, fetching *slots, resultCh chan<- Result,
+	wg *sync.WaitGroup,
+) {
 	defer wg.Done()
 
 	for {
@@ -81,49 +375,280 @@ func (c *Service) worker(ctx context.Context, ch <-chan string, resultCh chan<-
 		default:
 		}
 
+		if fetching.acquire(ctx) != nil {
+			return
+		}
 		select {
 		case <-ctx.Done():
+			fetching.release()
 			return
-		case url, ok := <-ch:
+		case target, ok := <-ch:
 			if !ok {
+				fetching.release()
 				return
 			}
-			data, err := c.httpRequest(ctx, url)
-			if err != nil {
-				resultCh <- resultCrawl{
-					Err: err,
-					URL: url,
-				}
+			res := c.fetchResult(ctx, target)
+			fetching.release()
+			select {
+			case resultCh <- res:
+			case <-abort:
 				return
 			}
-			resultCh <- resultCrawl{
-				Data: data,
-				URL:  url,
+			if res.Err != nil {
+				return
 			}
 		}
 	}
 }
 
-func (c *Service) httpRequest(ctx context.Context, link string) ([]byte, error) {
+// fetchResult fetches a single target, recording its timings when the batch
+// asks for them, and reports it to the progress of the batch.
+func (c *Service) fetchResult(ctx context.Context, target Target) Result {
+	progress := progressFrom(ctx)
+	progress.start(target.URL)
+	defer progress.done(target.URL)
+
+	var timings *timingsCollector
+	if ctx.Value(recordTimingsKey{}) != nil {
+		timings = &timingsCollector{}
+		ctx = withTimingsCollector(ctx, timings)
+	}
+
+	data, err := c.fetch(ctx, target)
+	res := Result{URL: target.URL, Data: data, Err: err}
+	if timings != nil {
+		res.Timings = timings.get()
+	}
+	return res
+}
+
+// fetch downloads a single target and reports it to the metrics.
+func (c *Service) fetch(ctx context.Context, target Target) ([]byte, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.metrics.fetchStarted()
+	spanCtx, span := c.tracer.Start(ctx, "crawl.fetch", tracing.KindClient,
+		slog.String(logger.KeyURL, target.URL),
+		slog.String(logger.KeyHost, u.Host),
+	)
+	start := time.Now()
+	data, err := c.fetchBreaker(spanCtx, u, target)
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		data, err = statusErr.body, nil
+	}
+	latency := time.Since(start)
+	c.metrics.fetchDone(ctx, u.Hostname(), latency, len(data), err)
+	span.SetAttrs(slog.String("outcome", fetchOutcome(ctx, err)), slog.Int("bytes", len(data)))
+	span.SetError(err)
+	span.End()
+
+	attrs := []slog.Attr{
+		slog.String(logger.KeyURL, target.URL),
+		slog.String(logger.KeyHost, u.Host),
+		slog.Duration(logger.KeyDuration, latency),
+	}
+	if err != nil {
+		c.logger.LogAttrs(ctx, slog.LevelDebug, "fetch failed", append(attrs,
+			logger.Err(err),
+			slog.String(logger.KeyErrorClass, fetchOutcome(ctx, err)),
+		)...)
+		return nil, err
+	}
+	c.logger.LogAttrs(ctx, slog.LevelDebug, "fetched", append(attrs, slog.Int("bytes", len(data)))...)
+	return data, nil
+}
+
+// fetchBreaker downloads a single target through the host's circuit breaker.
+func (c *Service) fetchBreaker(ctx context.Context, u *url.URL, target Target) ([]byte, error) {
+	if c.breakers == nil {
+		return c.fetchLimited(ctx, u.Host, target)
+	}
+
+	b := c.breakers.Get(u.Host)
+	if err := b.Allow(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, u.Host)
+	}
+
+	data, err := c.fetchLimited(ctx, u.Host, target)
+	if err != nil && !c.hostFailure(ctx, target, err) {
+		// this says nothing about the host
+		b.Cancel()
+		return nil, err
+	}
+	b.Done(err == nil)
+	return data, err
+}
+
+// fetchLimited downloads a single target within the host's adaptive concurrency limit.
+func (c *Service) fetchLimited(ctx context.Context, host string, target Target) ([]byte, error) {
+	if c.concurrency == nil {
+		release, err := c.acquireOutbound(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return c.fetchWithTimeout(ctx, target)
+	}
+
+	done, err := c.concurrency.acquire(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// the wait for an outbound slot is no latency of the host
+	release, err := c.acquireOutbound(ctx)
+	if err != nil {
+		done(0, true, true)
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	data, err := c.fetchWithTimeout(ctx, target)
+	done(time.Since(start), err != nil, err != nil && !c.hostFailure(ctx, target, err))
+	return data, err
+}
+
+// acquireOutbound takes a slot of the outbound limit, if there is one, before
+// the timeout of the fetch starts: the time in the queue is bounded by the
+// queue timeout, not taken from the upstream.
+func (c *Service) acquireOutbound(ctx context.Context) (func(), error) {
+	if c.outbound == nil {
+		return func() {}, nil
+	}
+	if err := c.outbound.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return c.outbound.release, nil
+}
+
+// fetchWithTimeout downloads a single target within its timeout, hedging the request when enabled.
+func (c *Service) fetchWithTimeout(ctx context.Context, target Target) ([]byte, error) {
 	var cancel context.CancelFunc
-	ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+	ctx, cancel = context.WithTimeout(ctx, c.timeout(ctx, target))
 	defer cancel()
 
+	if c.hedger == nil {
+		return c.httpRequest(ctx, target.URL)
+	}
+
+	// every fetch is a GET, so it is safe to send it twice
+	data, hedged, err := c.hedger.Do(ctx, func(ctx context.Context, hedge bool) ([]byte, error) {
+		if hedge && c.outbound != nil {
+			// the first request holds a slot already, a hedge goes out only
+			// if another one is free right away
+			if !c.outbound.tryAcquire() {
+				return nil, ErrOutboundBusy
+			}
+			defer c.outbound.release()
+		}
+		return c.httpRequest(ctx, target.URL)
+	})
+	if hedged {
+		c.logger.LogAttrs(ctx, slog.LevelDebug, "hedged request", slog.String(logger.KeyURL, target.URL))
+	}
+	return data, err
+}
+
+// redirectCheck is the type of http.Client.CheckRedirect.
+type redirectCheck func(req *http.Request, via []*http.Request) error
+
+// checkRedirect applies the host policy to redirects, so that an allowed host
+// can't send the crawler to a blocked one. next is the client's own check.
+func (c *Service) checkRedirect(next redirectCheck) redirectCheck {
+	return func(req *http.Request, via []*http.Request) error {
+		if !c.hosts.Load().Allows(req.URL.Hostname()) {
+			return fmt.Errorf("%w: redirect to %s", ErrHostNotAllowed, req.URL.Host)
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		// the default policy of http.Client
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+}
+
+// requestTimeoutKey holds the request timeout of a batch, read at its start so
+// that a batch in flight keeps it when the timeout is changed.
+type requestTimeoutKey struct{}
+
+// requestTimeoutOf returns the request timeout of the batch of ctx.
+func (c *Service) requestTimeoutOf(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(requestTimeoutKey{}).(time.Duration); ok {
+		return d
+	}
+	return time.Duration(c.requestTimeout.Load())
+}
+
+// timeout is the timeout of the target's fetch: its own when set, the batch one otherwise.
+func (c *Service) timeout(ctx context.Context, target Target) time.Duration {
+	if target.Timeout > 0 {
+		return target.Timeout
+	}
+	return c.requestTimeoutOf(ctx)
+}
+
+// hostFailure reports whether a failed fetch tells about the health of the
+// host, rather than the batch having been cancelled, the process being out of
+// slots, a redirect to a host that isn't allowed or the client having given
+// the target less time than the service would. ctx is the batch context, the
+// fetch's own deadline is judged by its timeout.
+func (c *Service) hostFailure(ctx context.Context, target Target, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrOutboundBusy) || errors.Is(err, ErrHostNotAllowed) {
+		return false
+	}
+	shortened := target.Timeout > 0 && target.Timeout < c.requestTimeoutOf(ctx)
+	return !shortened || !errors.Is(err, context.DeadlineExceeded)
+}
+
+func (c *Service) httpRequest(ctx context.Context, link string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
 	if err != nil {
 		return nil, err
 	}
+	if id, ok := requestid.FromContext(ctx); ok {
+		req.Header.Set(requestid.Header, id)
+	}
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		req.Header.Set(tracing.HeaderTraceparent, span.Context().Traceparent())
+		req = req.WithContext(tracing.WithClientTrace(req.Context()))
+	}
+	collector := timingsCollectorFrom(ctx)
+	var timings *attemptTimings
+	if collector != nil {
+		timings = &attemptTimings{}
+		req = req.WithContext(timings.trace(req.Context()))
+	}
+
+	fail := func(err error) ([]byte, error) {
+		if timings != nil {
+			collector.setFailed(timings.done())
+		}
+		return nil, err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 	defer resp.Body.Close()
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return fail(err)
+	}
+	if timings != nil {
+		collector.set(timings.done())
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &statusError{code: resp.StatusCode, body: data}
 	}
 
-	return data, err
+	return data, nil
 }
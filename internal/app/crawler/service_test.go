@@ -3,13 +3,22 @@ package crawler_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/apoldev/go-http/internal/app/breaker"
 	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/apoldev/go-http/internal/app/metrics"
+	"github.com/apoldev/go-http/internal/app/tracing"
 	"github.com/stretchr/testify/require"
 )
 
@@ -42,7 +51,7 @@ func getFakeHTTPClient(datas map[string][]byte) *http.Client {
 }
 
 func TestService_Crawl(t *testing.T) {
-	logger := log.New(io.Discard, "", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	// Fake external servers
 	urls := map[string][]byte{
@@ -133,3 +142,629 @@ func TestService_Crawl(t *testing.T) {
 		})
 	}
 }
+
+func TestService_CrawlBatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	urls := map[string][]byte{
+		"http://google.com": []byte(`[4,5,6]`),
+		"http://yandex.ru":  []byte(`<html><body>hello</body></html>`),
+		"http://mail.ru":    []byte(`<html><body>mail</body></html>`),
+	}
+	client := getFakeHTTPClient(urls)
+
+	t.Run("batch_deadline", func(t *testing.T) {
+		c := crawler.New(1, 1000, client, logger)
+		results, err := c.CrawlBatch(context.Background(), crawler.Batch{
+			Targets: []crawler.Target{
+				{URL: "http://google.com"},
+				{URL: "http://yandex.ru"},
+				{URL: "http://mail.ru"},
+			},
+			Deadline: 150 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		require.NoError(t, results["http://google.com"].Err)
+		require.Equal(t, urls["http://google.com"], results["http://google.com"].Data)
+		require.ErrorIs(t, results["http://yandex.ru"].Err, crawler.ErrTimedOut)
+		require.ErrorIs(t, results["http://mail.ru"].Err, crawler.ErrTimedOut)
+	})
+
+	t.Run("url_timeout_override", func(t *testing.T) {
+		c := crawler.New(1, 1000, client, logger)
+		_, err := c.CrawlBatch(context.Background(), crawler.Batch{
+			Targets: []crawler.Target{
+				{URL: "http://google.com", Timeout: 50 * time.Millisecond},
+			},
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// slowFirstTransport answers the first request only after a second and the rest immediately.
+type slowFirstTransport struct {
+	calls     atomic.Int32
+	cancelled atomic.Bool
+}
+
+func (s *slowFirstTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if s.calls.Add(1) == 1 {
+		select {
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			s.cancelled.Store(true)
+			return nil, req.Context().Err()
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader([]byte("ok"))),
+	}, nil
+}
+
+func TestService_Hedging(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cases := []struct {
+		name          string
+		cfg           crawler.HedgeConfig
+		expectedCalls int32
+		maxDuration   time.Duration
+	}{
+		{
+			name:          "hedged",
+			cfg:           crawler.HedgeConfig{Delay: 50 * time.Millisecond, BudgetPercent: 100},
+			expectedCalls: 2,
+			maxDuration:   500 * time.Millisecond,
+		},
+		{
+			name:          "no_budget",
+			cfg:           crawler.HedgeConfig{Delay: 50 * time.Millisecond, BudgetPercent: 50},
+			expectedCalls: 1,
+			maxDuration:   2 * time.Second,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := &slowFirstTransport{}
+			c := crawler.New(1, 2000, &http.Client{Transport: transport}, logger, crawler.WithHedging(tc.cfg))
+
+			start := time.Now()
+			data, err := c.Crawl(context.Background(), []string{"http://google.com"})
+			require.NoError(t, err)
+			require.Equal(t, []byte("ok"), data["http://google.com"])
+			require.Less(t, time.Since(start), tc.maxDuration)
+			require.Equal(t, tc.expectedCalls, transport.calls.Load())
+
+			if tc.expectedCalls > 1 {
+				require.Eventually(t, transport.cancelled.Load, time.Second, 10*time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestService_Breaker(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := getFakeHTTPClient(map[string][]byte{})
+	breakers := breaker.NewSet(breaker.Config{FailureRate: 1, MinRequests: 1, Window: time.Minute, CoolDown: time.Minute})
+	c := crawler.New(1, 1000, client, logger, crawler.WithBreakers(breakers))
+
+	_, err := c.Crawl(context.Background(), []string{"http://down.example/a"})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, breaker.ErrOpen)
+
+	start := time.Now()
+	_, err = c.Crawl(context.Background(), []string{"http://down.example/b"})
+	require.ErrorIs(t, err, breaker.ErrOpen)
+	require.Less(t, time.Since(start), 50*time.Millisecond, "must fail fast without dialing")
+
+	// a timeout shorter than the service one is the client's choice, not the host's fault
+	breakers = breaker.NewSet(breaker.Config{FailureRate: 1, MinRequests: 1, Window: time.Minute, CoolDown: time.Minute})
+	c = crawler.New(1, 1000, client, logger, crawler.WithBreakers(breakers))
+	_, err = c.CrawlBatch(context.Background(), crawler.Batch{
+		Targets: []crawler.Target{{URL: "http://slow.example/a", Timeout: 10 * time.Millisecond}},
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, breaker.Closed, breakers.Get("slow.example").State())
+
+	// a server error is a failure of the host, but its body is still the result
+	failing := &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(bytes.NewReader([]byte("bad")))}
+	})}
+	breakers = breaker.NewSet(breaker.Config{FailureRate: 1, MinRequests: 1, Window: time.Minute, CoolDown: time.Minute})
+	c = crawler.New(1, 1000, failing, logger, crawler.WithBreakers(breakers))
+	data, err := c.Crawl(context.Background(), []string{"http://failing.example/a"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"http://failing.example/a": []byte("bad")}, data)
+	require.Equal(t, breaker.Open, breakers.Get("failing.example").State())
+}
+
+// concurrencyTransport records the highest number of requests in flight at once.
+type concurrencyTransport struct {
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+}
+
+func (c *concurrencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	n := c.inflight.Add(1)
+	defer c.inflight.Add(-1)
+	for {
+		m := c.maxInflight.Load()
+		if n <= m || c.maxInflight.CompareAndSwap(m, n) {
+			break
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader([]byte("ok"))),
+	}, nil
+}
+
+func TestService_AdaptiveConcurrency(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	transport := &concurrencyTransport{}
+	c := crawler.New(1, 1000, &http.Client{Transport: transport}, logger,
+		crawler.WithAdaptiveConcurrency(crawler.ConcurrencyConfig{
+			Initial:       8,
+			Min:           1,
+			Max:           8,
+			HostMin:       1,
+			HostMax:       2,
+			LatencyTarget: time.Second,
+		}),
+	)
+
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://google.com/%d", i)
+	}
+
+	data, err := c.Crawl(context.Background(), urls)
+	require.NoError(t, err)
+	require.Len(t, data, len(urls))
+	require.Equal(t, int32(2), transport.maxInflight.Load(), "per host limit must hold across workers")
+}
+
+// startsTransport records the number of requests in flight as every request starts.
+type startsTransport struct {
+	mu       sync.Mutex
+	inflight int
+	starts   []int
+}
+
+func (s *startsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	s.inflight++
+	s.starts = append(s.starts, s.inflight)
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.mu.Lock()
+	s.inflight--
+	s.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader([]byte("ok"))),
+	}, nil
+}
+
+func TestService_AdaptiveConcurrency_LongBatch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	transport := &startsTransport{}
+	c := crawler.New(1, 1000, &http.Client{Transport: transport}, logger,
+		crawler.WithAdaptiveConcurrency(crawler.ConcurrencyConfig{
+			Initial: 4,
+			Min:     1,
+			Max:     4,
+			HostMin: 1,
+			HostMax: 4,
+			// every fetch is too slow and backs off
+			LatencyTarget: time.Millisecond,
+		}),
+	)
+
+	urls := make([]string, 40)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://host%d.example/", i)
+	}
+	_, err := c.Crawl(context.Background(), urls)
+	require.NoError(t, err)
+
+	require.Equal(t, 4, slices.Max(transport.starts[:4]), "the batch starts with the initial limit")
+	require.Equal(t, 1, slices.Max(transport.starts[len(urls)-5:]), "the batch must back off while it runs")
+}
+
+func TestService_Pool(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	pool := crawler.NewPool(4)
+	defer pool.Close()
+
+	transport := &concurrencyTransport{}
+	c := crawler.New(2, 1000, &http.Client{Transport: transport}, logger, crawler.WithPool(pool))
+
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://google.com/%d", i)
+	}
+
+	data, err := c.Crawl(context.Background(), urls)
+	require.NoError(t, err)
+	require.Len(t, data, len(urls))
+	require.Equal(t, int32(2), transport.maxInflight.Load(), "a batch must not take more than its worker count")
+
+	pool.Close()
+	_, err = c.Crawl(context.Background(), urls)
+	require.ErrorIs(t, err, crawler.ErrPoolClosed)
+}
+
+func TestService_Outbound(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	outbound := crawler.NewOutbound(crawler.OutboundConfig{Limit: 2, QueueSize: 100, MaxWait: time.Second})
+	transport := &concurrencyTransport{}
+
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://google.com/%d", i)
+	}
+
+	// the limit is shared by independent services and batches
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		c := crawler.New(4, 1000, &http.Client{Transport: transport}, logger, crawler.WithOutbound(outbound))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := c.Crawl(context.Background(), urls)
+			require.NoError(t, err)
+			require.Len(t, data, len(urls))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(2), transport.maxInflight.Load())
+
+	stats := outbound.Stats()
+	require.Equal(t, uint64(3*len(urls)), stats.Acquired)
+	require.Zero(t, stats.InUse)
+	require.Zero(t, stats.Rejected)
+
+	// a full queue fails the fetch without blaming the host
+	busy := crawler.NewOutbound(crawler.OutboundConfig{Limit: 0, QueueSize: 0, MaxWait: time.Second})
+	breakers := breaker.NewSet(breaker.Config{FailureRate: 0.5, MinRequests: 1, Window: time.Minute, CoolDown: time.Minute})
+	c := crawler.New(1, 1000, &http.Client{Transport: transport}, logger,
+		crawler.WithOutbound(busy), crawler.WithBreakers(breakers))
+	_, err := c.Crawl(context.Background(), urls[:1])
+	require.ErrorIs(t, err, crawler.ErrOutboundBusy)
+	require.Equal(t, breaker.Closed, breakers.Get("google.com").State())
+	require.Equal(t, uint64(1), busy.Stats().Rejected)
+}
+
+func TestService_OutboundWaitIsNotTimedOut(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	outbound := crawler.NewOutbound(crawler.OutboundConfig{Limit: 1, QueueSize: 2, MaxWait: time.Second})
+	// every fetch takes 20ms, the last one waits 40ms for the slot
+	c := crawler.New(3, 50, &http.Client{Transport: &concurrencyTransport{}}, logger, crawler.WithOutbound(outbound))
+
+	urls := []string{"http://google.com/1", "http://google.com/2", "http://google.com/3"}
+	data, err := c.Crawl(context.Background(), urls)
+	require.NoError(t, err)
+	require.Len(t, data, len(urls))
+}
+
+func TestService_Metrics(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reg := metrics.NewRegistry()
+	c := crawler.New(1, 1000, &http.Client{Transport: instantTransport{}}, logger,
+		crawler.WithMetrics(crawler.NewMetrics(reg)))
+
+	_, err := c.Crawl(context.Background(), []string{"http://google.com/1", "http://google.com/2"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `crawler_fetch_duration_seconds_count{host="google.com",outcome="ok"} 2`)
+	require.Contains(t, buf.String(), "crawler_fetched_bytes_total 4\n")
+	require.Contains(t, buf.String(), "crawler_active_workers 0\n")
+}
+
+func TestService_Metrics_HostLabels(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reg := metrics.NewRegistry()
+	c := crawler.New(4, 1000, &http.Client{Transport: instantTransport{}}, logger,
+		crawler.WithMetrics(crawler.NewMetrics(reg)))
+
+	// the first 100 hosts get a label, the rest share one
+	urls := make([]string, 102)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://host%d.example/", i)
+	}
+	_, err := c.Crawl(context.Background(), urls[:100])
+	require.NoError(t, err)
+	_, err = c.Crawl(context.Background(), append(urls[100:], "http://HOST0.example/"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), `crawler_fetch_duration_seconds_count{host="host0.example",outcome="ok"} 2`)
+	require.Contains(t, buf.String(), `crawler_fetch_duration_seconds_count{host="host99.example",outcome="ok"} 1`)
+	require.Contains(t, buf.String(), `crawler_fetch_duration_seconds_count{host="other",outcome="ok"} 2`)
+	require.NotContains(t, buf.String(), `host="host100.example"`)
+}
+
+func TestService_Timings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok")) //nolint:errcheck // test server
+	}))
+	defer srv.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := crawler.New(1, 1000, srv.Client(), logger)
+
+	for _, reused := range []bool{false, true} {
+		results, err := c.CrawlBatch(context.Background(), crawler.Batch{
+			Targets: []crawler.Target{{URL: srv.URL}},
+			Timings: true,
+		})
+		require.NoError(t, err)
+
+		timings := results[srv.URL].Timings
+		require.NotNil(t, timings)
+		require.Equal(t, reused, timings.Reused)
+		require.GreaterOrEqual(t, timings.FirstByte, 10*time.Millisecond)
+		if !reused {
+			require.Positive(t, timings.Connect)
+		}
+	}
+
+	results, err := c.CrawlBatch(context.Background(), crawler.Batch{Targets: []crawler.Target{{URL: srv.URL}}})
+	require.NoError(t, err)
+	require.Nil(t, results[srv.URL].Timings, "timings are recorded only on request")
+
+	// a fetch cut short by the deadline has the phases it has got through
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	c = crawler.New(1, 1000, slow.Client(), logger)
+	results, err = c.CrawlBatch(context.Background(), crawler.Batch{
+		Targets:  []crawler.Target{{URL: slow.URL}},
+		Deadline: 50 * time.Millisecond,
+		Timings:  true,
+	})
+	require.NoError(t, err)
+	require.ErrorIs(t, results[slow.URL].Err, crawler.ErrTimedOut)
+	timings := results[slow.URL].Timings
+	require.NotNil(t, timings)
+	require.Positive(t, timings.Connect)
+	require.Zero(t, timings.FirstByte)
+}
+
+// headerTransport records the request ID and traceparent of every request.
+type headerTransport struct {
+	mu           sync.Mutex
+	ids          []string
+	traceparents []string
+}
+
+func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.mu.Lock()
+	h.ids = append(h.ids, req.Header.Get(requestid.Header))
+	h.traceparents = append(h.traceparents, req.Header.Get(tracing.HeaderTraceparent))
+	h.mu.Unlock()
+	return instantTransport{}.RoundTrip(req)
+}
+
+func TestService_RequestID(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	transport := &headerTransport{}
+	c := crawler.New(2, 1000, &http.Client{Transport: transport}, logger)
+
+	ctx := requestid.NewContext(context.Background(), "abc")
+	_, err := c.Crawl(ctx, []string{"http://google.com/1", "http://google.com/2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"abc", "abc"}, transport.ids)
+	require.Equal(t, []string{"", ""}, transport.traceparents, "no tracer, no trace")
+}
+
+func TestService_Tracing(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tracer := tracing.NewTracer(tracing.NewStdoutExporter(io.Discard), logger)
+	defer tracer.Shutdown(context.Background()) //nolint:errcheck // nothing to flush to
+
+	transport := &headerTransport{}
+	c := crawler.New(1, 1000, &http.Client{Transport: transport}, logger, crawler.WithTracer(tracer))
+
+	ctx, root := tracer.Start(context.Background(), "request", tracing.KindServer)
+	_, err := c.Crawl(ctx, []string{"http://google.com/1"})
+	require.NoError(t, err)
+	root.End()
+
+	require.Len(t, transport.traceparents, 1)
+	sc, ok := tracing.ParseTraceparent(transport.traceparents[0])
+	require.True(t, ok)
+	require.Equal(t, root.Context().TraceID, sc.TraceID)
+	require.NotEqual(t, root.Context().SpanID, sc.SpanID, "the upstream gets the fetch span as its parent")
+}
+
+func TestService_Progress(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+	}))
+	defer srv.Close()
+	c := crawler.New(2, 5000, srv.Client(), logger)
+
+	progress := crawler.NewProgress()
+	done := make(chan error)
+	go func() {
+		ctx := crawler.WithProgress(context.Background(), progress)
+		_, err := c.Crawl(ctx, []string{srv.URL + "/fast", srv.URL + "/slow"})
+		done <- err
+	}()
+
+	require.Eventually(t, func() bool {
+		completed, fetching := progress.Snapshot()
+		return completed == 1 && len(fetching) == 1 && fetching[0] == srv.URL+"/slow"
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-done)
+	completed, fetching := progress.Snapshot()
+	require.Equal(t, 2, completed)
+	require.Empty(t, fetching)
+}
+
+// instantTransport answers every request immediately.
+type instantTransport struct{}
+
+func (instantTransport) RoundTrip(_ *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader([]byte("ok"))),
+	}, nil
+}
+
+func BenchmarkCrawlPool(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := &http.Client{Transport: instantTransport{}}
+	pool := crawler.NewPool(100)
+	defer pool.Close()
+
+	cases := []struct {
+		name    string
+		service *crawler.Service
+		urls    int
+	}{
+		{name: "goroutines_1", service: crawler.New(4, 1000, client, logger), urls: 1},
+		{name: "pool_1", service: crawler.New(4, 1000, client, logger, crawler.WithPool(pool)), urls: 1},
+		{name: "goroutines_20", service: crawler.New(4, 1000, client, logger), urls: 20},
+		{name: "pool_20", service: crawler.New(4, 1000, client, logger, crawler.WithPool(pool)), urls: 20},
+	}
+
+	for _, c := range cases {
+		c := c
+		urls := make([]string, c.urls)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("http://google.com/%d", i)
+		}
+
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.service.Crawl(context.Background(), urls); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestService_Hosts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := getFakeHTTPClient(map[string][]byte{
+		"http://google.com":     []byte("google"),
+		"http://api.google.com": []byte("api"),
+		"http://yandex.ru":      []byte("yandex"),
+	})
+	c := crawler.New(2, 1000, client, logger, crawler.WithHosts(crawler.HostPolicy{
+		Allowed: []string{"google.com"},
+		Blocked: []string{"ads.google.com"},
+	}))
+
+	_, err := c.Crawl(context.Background(), []string{"http://google.com", "http://api.google.com"})
+	require.NoError(t, err)
+
+	_, err = c.Crawl(context.Background(), []string{"http://google.com", "http://yandex.ru"})
+	require.ErrorIs(t, err, crawler.ErrHostNotAllowed)
+	_, err = c.Crawl(context.Background(), []string{"http://ads.google.com:8080/banner"})
+	require.ErrorIs(t, err, crawler.ErrHostNotAllowed)
+
+	c.SetHosts(crawler.HostPolicy{})
+	_, err = c.Crawl(context.Background(), []string{"http://yandex.ru"})
+	require.NoError(t, err)
+}
+
+func TestService_HostsRedirect(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/local" {
+			_, _ = w.Write([]byte("local"))
+			return
+		}
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	client := srv.Client()
+	c := crawler.New(1, 1000, client, logger, crawler.WithHosts(crawler.HostPolicy{
+		Blocked: []string{"internal.example"},
+	}))
+
+	data, err := c.Crawl(context.Background(), []string{srv.URL + "/?to=/local"})
+	require.NoError(t, err)
+	require.Equal(t, "local", string(data[srv.URL+"/?to=/local"]))
+
+	_, err = c.Crawl(context.Background(), []string{srv.URL + "/?to=http://internal.example/secret"})
+	require.ErrorIs(t, err, crawler.ErrHostNotAllowed)
+	require.Nil(t, client.CheckRedirect, "the client of the caller must be left as it is")
+}
+
+func TestService_SetRequestTimeout(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := getFakeHTTPClient(map[string][]byte{"http://google.com": []byte("google")})
+	c := crawler.New(1, 1000, client, logger)
+
+	// every fake fetch takes 100ms
+	c.SetRequestTimeout(20 * time.Millisecond)
+	_, err := c.Crawl(context.Background(), []string{"http://google.com"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	c.SetRequestTimeout(time.Second)
+	_, err = c.Crawl(context.Background(), []string{"http://google.com"})
+	require.NoError(t, err)
+
+	// a batch in flight keeps its timeout, the second fetch starts after the change
+	client = getFakeHTTPClient(map[string][]byte{"http://google.com": []byte("google"), "http://yandex.ru": nil})
+	c = crawler.New(1, 1000, client, logger)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.SetRequestTimeout(20 * time.Millisecond)
+	}()
+	_, err = c.Crawl(context.Background(), []string{"http://google.com", "http://yandex.ru"})
+	require.NoError(t, err)
+}
+
+func TestService_SetWorkerCount(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	urls := map[string][]byte{
+		"http://a.com": []byte("a"),
+		"http://b.com": []byte("b"),
+		"http://c.com": []byte("c"),
+		"http://d.com": []byte("d"),
+	}
+	list := make([]string, 0, len(urls))
+	for u := range urls {
+		list = append(list, u)
+	}
+	c := crawler.New(1, 1000, getFakeHTTPClient(urls), logger)
+
+	// every fake fetch takes 100ms, one worker fetches them one by one
+	c.SetWorkerCount(4)
+	start := time.Now()
+	_, err := c.Crawl(context.Background(), list)
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 300*time.Millisecond)
+}
@@ -0,0 +1,133 @@
+package crawler
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings is the breakdown of fetching a single URL. Phases that did not
+// happen, e.g. DNS and connect on a reused connection or whatever comes after
+// the failure of a failed fetch, are zero.
+type Timings struct {
+	DNS          time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	// FirstByte is the time from sending the request to the first byte of the response.
+	FirstByte time.Duration
+	// Download is the time from the first byte to the end of the body.
+	Download time.Duration
+	Reused   bool
+}
+
+// attemptTimings records the timings of a single HTTP request. The httptrace
+// hooks may run on dialing goroutines, hence the mutex.
+type attemptTimings struct {
+	mu                                      sync.Mutex
+	t                                       Timings
+	start, dnsStart, connectStart, tlsStart time.Time
+	firstByte                               time.Time
+}
+
+func (a *attemptTimings) trace(ctx context.Context) context.Context {
+	lock := func(f func(now time.Time)) {
+		now := time.Now()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		f(now)
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock(func(time.Time) { a.t.Reused = info.Reused })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock(func(now time.Time) { a.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock(func(now time.Time) { a.t.DNS = now.Sub(a.dnsStart) })
+		},
+		ConnectStart: func(_, _ string) {
+			lock(func(now time.Time) { a.connectStart = now })
+		},
+		ConnectDone: func(_, _ string, _ error) {
+			lock(func(now time.Time) { a.t.Connect = now.Sub(a.connectStart) })
+		},
+		TLSHandshakeStart: func() {
+			lock(func(now time.Time) { a.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func(now time.Time) { a.t.TLSHandshake = now.Sub(a.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			lock(func(now time.Time) { a.start = now })
+		},
+		GotFirstResponseByte: func() {
+			lock(func(now time.Time) {
+				a.firstByte = now
+				a.t.FirstByte = now.Sub(a.start)
+			})
+		},
+	})
+}
+
+// done records the end of the body download.
+func (a *attemptTimings) done() Timings {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.firstByte.IsZero() {
+		a.t.Download = time.Since(a.firstByte)
+	}
+	return a.t
+}
+
+// timingsCollector keeps the timings of the request whose response is used:
+// with hedging the first successful one. When all requests fail, it keeps the
+// partial timings of the first failure, to tell where the fetch got stuck.
+type timingsCollector struct {
+	mu      sync.Mutex
+	timings *Timings
+	failed  *Timings
+}
+
+func (c *timingsCollector) set(t Timings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timings == nil {
+		c.timings = &t
+	}
+}
+
+func (c *timingsCollector) setFailed(t Timings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed == nil {
+		c.failed = &t
+	}
+}
+
+func (c *timingsCollector) get() *Timings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timings != nil {
+		return c.timings
+	}
+	return c.failed
+}
+
+type (
+	// recordTimingsKey marks the context of a batch that records timings.
+	recordTimingsKey struct{}
+	timingsKey       struct{}
+)
+
+func withTimingsCollector(ctx context.Context, c *timingsCollector) context.Context {
+	return context.WithValue(ctx, timingsKey{}, c)
+}
+
+func timingsCollectorFrom(ctx context.Context) *timingsCollector {
+	c, _ := ctx.Value(timingsKey{}).(*timingsCollector)
+	return c
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/inflight"
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+type BreakerStater interface {
+	Statuses() []breaker.Status
+}
+
+type OutboundStater interface {
+	Stats() crawler.OutboundStats
+}
+
+type CrawlRegistry interface {
+	List() []inflight.Crawl
+	Cancel(id string) (inflight.Crawl, bool)
+}
+
+// CrawlsPath is where the Crawls handler is mounted, a crawl is at CrawlsPath/{id}.
+const CrawlsPath = "/admin/crawls"
+
+// AdminHandler serves operational endpoints.
+type AdminHandler struct {
+	breakers BreakerStater
+	outbound OutboundStater
+	limiters map[string]limiter.Stater
+	crawls   CrawlRegistry
+
+	mu     sync.Mutex
+	config any
+}
+
+type AdminOption func(h *AdminHandler)
+
+// WithLimiters shows the state of the limiters by name.
+func WithLimiters(limiters map[string]limiter.Stater) AdminOption {
+	return func(h *AdminHandler) {
+		h.limiters = limiters
+	}
+}
+
+// WithConfig shows the configuration in effect. Secrets must be redacted by the caller.
+func WithConfig(config any) AdminOption {
+	return func(h *AdminHandler) {
+		h.config = config
+	}
+}
+
+// WithCrawls lists the crawls in flight and lets operators cancel them.
+func WithCrawls(crawls CrawlRegistry) AdminOption {
+	return func(h *AdminHandler) {
+		h.crawls = crawls
+	}
+}
+
+// NewAdminHandler creates the handler. outbound may be nil when the outbound limit is off.
+func NewAdminHandler(breakers BreakerStater, outbound OutboundStater, opts ...AdminOption) *AdminHandler {
+	h := &AdminHandler{
+		breakers: breakers,
+		outbound: outbound,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// LimiterState is the state of a limiter, the durations are in milliseconds.
+type LimiterState struct {
+	Limit        int   `json:"limit"`
+	Remaining    int   `json:"remaining"`
+	ResetMs      int64 `json:"reset_ms"`
+	RetryAfterMs int64 `json:"retry_after_ms"`
+}
+
+// BuildInfo is the build information of the binary.
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      []string          `json:"deps"`
+}
+
+// Breakers is a handler that shows the circuit breaker state of every upstream host.
+func (h *AdminHandler) Breakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	httpresp.WriteJSON(w, h.breakers.Statuses(), http.StatusOK)
+}
+
+// Outbound is a handler that shows the usage of the process-wide outbound limit.
+func (h *AdminHandler) Outbound(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.outbound == nil {
+		httpresp.Error(w, "Outbound limit is disabled", http.StatusNotFound)
+		return
+	}
+	httpresp.WriteJSON(w, h.outbound.Stats(), http.StatusOK)
+}
+
+// Limiters is a handler that shows the state of the global limiters.
+func (h *AdminHandler) Limiters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	states := make(map[string]LimiterState, len(h.limiters))
+	for name, l := range h.limiters {
+		s := l.State()
+		states[name] = LimiterState{
+			Limit:        s.Limit,
+			Remaining:    s.Remaining,
+			ResetMs:      s.Reset.Milliseconds(),
+			RetryAfterMs: s.RetryAfter.Milliseconds(),
+		}
+	}
+	httpresp.WriteJSON(w, states, http.StatusOK)
+}
+
+// Config is a handler that shows the configuration in effect.
+func (h *AdminHandler) Config(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mu.Lock()
+	config := h.config
+	h.mu.Unlock()
+	httpresp.WriteJSON(w, config, http.StatusOK)
+}
+
+// SetConfig replaces the configuration shown, e.g. after a reload. Secrets
+// must be redacted by the caller.
+func (h *AdminHandler) SetConfig(config any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config = config
+}
+
+// BuildInfo is a handler that shows the Go version, module version, VCS
+// revision and dependencies the binary was built with.
+func (h *AdminHandler) BuildInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		httpresp.Error(w, "Build info is not available", http.StatusNotFound)
+		return
+	}
+
+	info := BuildInfo{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Version:   bi.Main.Version,
+		Settings:  make(map[string]string, len(bi.Settings)),
+		Deps:      make([]string, 0, len(bi.Deps)),
+	}
+	for _, s := range bi.Settings {
+		info.Settings[s.Key] = s.Value
+	}
+	for _, d := range bi.Deps {
+		info.Deps = append(info.Deps, d.Path+"@"+d.Version)
+	}
+	httpresp.WriteJSON(w, info, http.StatusOK)
+}
+
+// Crawls is a handler for the crawls in flight: GET CrawlsPath lists them,
+// GET CrawlsPath/{id} shows one and DELETE CrawlsPath/{id} cancels it. The
+// cancelled crawl answers its client with 503.
+func (h *AdminHandler) Crawls(w http.ResponseWriter, r *http.Request) {
+	if h.crawls == nil {
+		httpresp.Error(w, "Crawl registry is disabled", http.StatusNotFound)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, CrawlsPath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		httpresp.WriteJSON(w, h.crawls.List(), http.StatusOK)
+	case id != "" && r.Method == http.MethodGet:
+		for _, c := range h.crawls.List() {
+			if c.ID == id {
+				httpresp.WriteJSON(w, c, http.StatusOK)
+				return
+			}
+		}
+		httpresp.Error(w, "Crawl not found", http.StatusNotFound)
+	case id != "" && r.Method == http.MethodDelete:
+		c, ok := h.crawls.Cancel(id)
+		if !ok {
+			httpresp.Error(w, "Crawl not found", http.StatusNotFound)
+			return
+		}
+		// the crawl stops once its fetches in flight have noticed
+		httpresp.WriteJSON(w, c, http.StatusAccepted)
+	default:
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
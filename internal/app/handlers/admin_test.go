@@ -0,0 +1,52 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/handlers"
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	atom := limiter.NewAtomLimiter(2)
+	require.True(t, atom.Take())
+
+	h := handlers.NewAdminHandler(breaker.NewSet(breaker.Config{}), nil,
+		handlers.WithLimiters(map[string]limiter.Stater{"concurrency": atom}),
+		handlers.WithConfig(map[string]any{"ADDR": ":8080"}),
+	)
+
+	t.Run("limiters", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Limiters(w, httptest.NewRequest(http.MethodGet, "/admin/limiters", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var states map[string]handlers.LimiterState
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &states))
+		require.Equal(t, handlers.LimiterState{Limit: 2, Remaining: 1}, states["concurrency"])
+	})
+
+	t.Run("config", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Config(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"ADDR":":8080"}`, w.Body.String())
+	})
+
+	t.Run("outbound_disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Outbound(w, httptest.NewRequest(http.MethodGet, "/admin/outbound", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("bad_method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.Limiters(w, httptest.NewRequest(http.MethodPost, "/admin/limiters", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+)
+
+const (
+	healthOK           = "ok"
+	healthFailing      = "failing"
+	healthShuttingDown = "shutting down"
+)
+
+// Probe checks a dependency the service can't work without, e.g. that an
+// upstream is reachable.
+type Probe struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HTTPProbe checks that url answers with a status below 500.
+func HTTPProbe(client *http.Client, url string) Probe {
+	return Probe{
+		Name: url,
+		Check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("status %d", resp.StatusCode)
+			}
+			return nil
+		},
+	}
+}
+
+// HealthResponse is the body of the health endpoints.
+type HealthResponse struct {
+	Status string `json:"status"`
+	// Checks are the probe results by name, "ok" or the error.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// HealthHandler serves the liveness and readiness endpoints.
+type HealthHandler struct {
+	probes       []Probe
+	probeTimeout time.Duration
+	shuttingDown atomic.Bool
+}
+
+// NewHealthHandler creates the handler. Readiness runs the probes concurrently,
+// each for at most probeTimeout.
+func NewHealthHandler(probes []Probe, probeTimeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		probes:       probes,
+		probeTimeout: probeTimeout,
+	}
+}
+
+// ShutDown makes readiness fail, so that load balancers stop sending requests
+// before the server stops accepting them.
+func (h *HealthHandler) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Livez is a handler that answers 200 while the process is able to serve requests.
+func (h *HealthHandler) Livez(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	httpresp.WriteJSON(w, HealthResponse{Status: healthOK}, http.StatusOK)
+}
+
+// Readyz is a handler that answers 200 when the service should get traffic:
+// it is not shutting down and all the probes pass. Otherwise it answers 503.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpresp.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.shuttingDown.Load() {
+		httpresp.WriteJSON(w, HealthResponse{Status: healthShuttingDown}, http.StatusServiceUnavailable)
+		return
+	}
+	if len(h.probes) == 0 {
+		httpresp.WriteJSON(w, HealthResponse{Status: healthOK}, http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.probeTimeout)
+	defer cancel()
+
+	resp := HealthResponse{Status: healthOK, Checks: make(map[string]string, len(h.probes))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, p := range h.probes {
+		wg.Add(1)
+		go func(p Probe) {
+			defer wg.Done()
+			result := healthOK
+			if err := p.Check(ctx); err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[p.Name] = result
+			if result != healthOK {
+				resp.Status = healthFailing
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	if resp.Status != healthOK {
+		code = http.StatusServiceUnavailable
+	}
+	httpresp.WriteJSON(w, resp, code)
+}
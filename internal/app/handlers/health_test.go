@@ -0,0 +1,74 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/handlers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer upstream.Close()
+
+	readyz := func(h *handlers.HealthHandler) (int, handlers.HealthResponse) {
+		w := httptest.NewRecorder()
+		h.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp handlers.HealthResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	t.Run("shutting_down", func(t *testing.T) {
+		h := handlers.NewHealthHandler(nil, time.Second)
+		code, _ := readyz(h)
+		require.Equal(t, http.StatusOK, code)
+
+		h.ShutDown()
+		code, resp := readyz(h)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "shutting down", resp.Status)
+
+		// the process is still alive while draining
+		w := httptest.NewRecorder()
+		h.Livez(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("probes", func(t *testing.T) {
+		failing := handlers.Probe{
+			Name:  "db",
+			Check: func(context.Context) error { return errors.New("connection refused") },
+		}
+		reachable := handlers.HTTPProbe(upstream.Client(), upstream.URL)
+		h := handlers.NewHealthHandler([]handlers.Probe{reachable}, time.Second)
+		code, resp := readyz(h)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, map[string]string{upstream.URL: "ok"}, resp.Checks)
+
+		h = handlers.NewHealthHandler([]handlers.Probe{reachable, failing}, time.Second)
+		code, resp = readyz(h)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "failing", resp.Status)
+		require.Equal(t, "connection refused", resp.Checks["db"])
+	})
+
+	t.Run("probe_timeout", func(t *testing.T) {
+		slow := handlers.Probe{
+			Name: "slow",
+			Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}
+		h := handlers.NewHealthHandler([]handlers.Probe{slow}, 10*time.Millisecond)
+		code, _ := readyz(h)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+	})
+}
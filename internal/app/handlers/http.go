@@ -5,35 +5,165 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/inflight"
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
 	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+	"github.com/apoldev/go-http/internal/app/limiter"
 	"github.com/apoldev/go-http/pkg/logger"
 )
 
+// HeaderRequestTimeout is a client-supplied budget for the whole batch,
+// either in milliseconds or as a Go duration string (e.g. "1500ms", "2s").
+const HeaderRequestTimeout = "X-Request-Timeout"
+
+// queryDebug turns the debug mode on for clients that send a plain array of URLs.
+const queryDebug = "debug"
+
+const (
+	statusOK      = "ok"
+	statusTimeout = "timeout"
+	statusError   = "error"
+)
+
 //go:generate go run github.com/vektra/mockery/v2@v2.40.1 --name Service
 type Service interface {
-	Crawl(ctx context.Context, urls []string) (map[string][]byte, error)
+	CrawlBatch(ctx context.Context, batch crawler.Batch) (map[string]crawler.Result, error)
+}
+
+type Admission interface {
+	TryAcquire(n int64) bool
+	Release(n int64)
 }
 
 // HTTPHandler is a handler for http request.
 type HTTPHandler struct {
-	crawlService Service
-	maxUrls      int
-	logger       logger.Logger
+	crawlService      Service
+	maxUrls           atomic.Int64
+	maxBatchTimeout   time.Duration
+	maxRequestTimeout time.Duration
+	admission         Admission
+	inflight          *inflight.Registry
+	logger            *slog.Logger
+}
+
+// Option configures optional HTTPHandler settings.
+type Option func(h *HTTPHandler)
+
+// WithTimeoutLimits bounds client-supplied timeouts: maxBatch caps the overall
+// batch deadline and maxRequest caps per-URL overrides. Zero leaves a limit unset.
+func WithTimeoutLimits(maxBatch, maxRequest time.Duration) Option {
+	return func(h *HTTPHandler) {
+		h.maxBatchTimeout = maxBatch
+		h.maxRequestTimeout = maxRequest
+	}
+}
+
+// WithAdmission charges every request with its number of URLs against the
+// admission semaphore, so that total in-flight URL fetches are capped.
+func WithAdmission(a Admission) Option {
+	return func(h *HTTPHandler) {
+		h.admission = a
+	}
+}
+
+// WithInflight registers every crawl in the registry while it runs, so that
+// it can be listed and cancelled by operators.
+func WithInflight(r *inflight.Registry) Option {
+	return func(h *HTTPHandler) {
+		h.inflight = r
+	}
 }
 
-func NewHTTPHandler(crawlService Service, maxUrls int, logger logger.Logger) *HTTPHandler {
-	return &HTTPHandler{
+func NewHTTPHandler(crawlService Service, maxUrls int, log *slog.Logger, opts ...Option) *HTTPHandler {
+	h := &HTTPHandler{
 		crawlService: crawlService,
-		maxUrls:      maxUrls,
-		logger:       logger,
+		logger:       log.With(logger.KeyComponent, "http"),
+	}
+	h.maxUrls.Store(int64(maxUrls))
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// SetMaxURLs changes the max number of URLs in a request.
+func (h *HTTPHandler) SetMaxURLs(n int) {
+	h.maxUrls.Store(int64(n))
+}
+
+// CrawlURL is a single URL of a crawl request. It is decoded either from a plain
+// string or from an object with a per-URL timeout override.
+type CrawlURL struct {
+	URL       string `json:"url"`
+	TimeoutMs int    `json:"timeout_ms,omitempty"`
+}
+
+func (u *CrawlURL) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		u.TimeoutMs = 0
+		return json.Unmarshal(b, &u.URL)
 	}
+	type plain CrawlURL
+	return json.Unmarshal(b, (*plain)(u))
+}
+
+// CrawlRequest is a body of the crawl request. It is decoded either from a plain
+// array of URLs or from an object with the URLs and the batch options.
+type CrawlRequest struct {
+	URLs      []CrawlURL `json:"urls"`
+	TimeoutMs int        `json:"timeout_ms,omitempty"`
+	// Debug adds the timings of every fetch to the response.
+	Debug bool `json:"debug,omitempty"`
+
+	// extended is set when the body is an object and the client expects the detailed response.
+	extended bool
+}
+
+func (r *CrawlRequest) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		r.extended = false
+		return json.Unmarshal(b, &r.URLs)
+	}
+	type plain CrawlRequest
+	if err := json.Unmarshal(b, (*plain)(r)); err != nil {
+		return err
+	}
+	r.extended = true
+	return nil
 }
 
-type CrawlRequest []string
 type CrawlResponse map[string]string
 
+// CrawlDetailedResponse is returned for extended requests. Every requested URL
+// has an entry; URLs not fetched within the batch deadline have status "timeout".
+type CrawlDetailedResponse map[string]CrawlURLResult
+
+type CrawlURLResult struct {
+	Status  string      `json:"status"`
+	Body    string      `json:"body,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Timings *URLTimings `json:"timings,omitempty"`
+}
+
+// URLTimings is the breakdown of fetching a URL in milliseconds, returned in debug mode.
+type URLTimings struct {
+	DNSMs          float64 `json:"dns_ms"`
+	ConnectMs      float64 `json:"connect_ms"`
+	TLSHandshakeMs float64 `json:"tls_handshake_ms"`
+	FirstByteMs    float64 `json:"first_byte_ms"`
+	DownloadMs     float64 `json:"download_ms"`
+	Reused         bool    `json:"reused"`
+}
+
 // Crawl is a handler for http request that helps crawl multiple URLs.
 func (h *HTTPHandler) Crawl(w http.ResponseWriter, r *http.Request) {
 	var err error
@@ -45,34 +175,217 @@ func (h *HTTPHandler) Crawl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// json
-	var urls CrawlRequest
+	var req CrawlRequest
 	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&urls)
+	err = decoder.Decode(&req)
 	if err != nil {
 		httpresp.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
+	entry := accesslog.FromContext(ctx)
+	entry.SetURLs(len(req.URLs))
+
 	// validate count of urls
-	if len(urls) > h.maxUrls {
-		httpresp.Error(w, fmt.Sprintf("Too many urls. Max is %d", h.maxUrls), http.StatusBadRequest)
+	if maxUrls := int(h.maxUrls.Load()); len(req.URLs) > maxUrls {
+		httpresp.Error(w, fmt.Sprintf("Too many urls. Max is %d", maxUrls), http.StatusBadRequest)
 		return
 	}
 
-	// call crawl()
-	data, err := h.crawlService.Crawl(ctx, urls)
-	if errors.Is(err, context.Canceled) {
-		httpresp.Error(w, fmt.Sprintf("request canceled: %s", err), http.StatusInternalServerError)
+	batch, err := h.batch(r, &req)
+	if err != nil {
+		httpresp.Error(w, fmt.Sprintf("Bad Request: %s", err), http.StatusBadRequest)
 		return
 	}
+
+	// admission
+	if h.admission != nil && len(batch.Targets) > 0 {
+		weight := int64(len(batch.Targets))
+		if !h.admission.TryAcquire(weight) {
+			h.setAdmissionState(w, true)
+			entry.SetLimiter(accesslog.OutcomeRejected)
+			httpresp.Error(w, "Too many urls in flight", http.StatusTooManyRequests)
+			return
+		}
+		defer h.admission.Release(weight)
+		h.setAdmissionState(w, false)
+		entry.SetLimiter(accesslog.OutcomeAdmitted)
+	}
+
+	if h.inflight != nil {
+		var done func()
+		ctx, done = h.inflight.Start(ctx, clientName(r), len(batch.Targets))
+		defer done()
+	}
+
+	// call crawl()
+	start := time.Now()
+	data, err := h.crawlService.CrawlBatch(ctx, batch)
 	if err != nil {
-		httpresp.Error(w, fmt.Sprintf("Internal Server Error: %s", err), http.StatusInternalServerError)
+		status, class, msg := http.StatusInternalServerError, "error", "Internal Server Error"
+		switch {
+		case errors.Is(context.Cause(ctx), inflight.ErrCancelled):
+			status, class, msg = http.StatusServiceUnavailable, "cancelled_by_operator", "Service Unavailable"
+			err = inflight.ErrCancelled
+		case errors.Is(err, context.Canceled):
+			class, msg = "cancelled", "request canceled"
+		case errors.Is(err, crawler.ErrHostNotAllowed):
+			status, class, msg = http.StatusForbidden, "host_not_allowed", "Forbidden"
+		case errors.Is(err, breaker.ErrOpen):
+			status, class, msg = http.StatusServiceUnavailable, "breaker_open", "Service Unavailable"
+		}
+		h.logger.LogAttrs(ctx, slog.LevelWarn, "crawl failed",
+			slog.Int(logger.KeyStatus, status),
+			slog.Duration(logger.KeyDuration, time.Since(start)),
+			logger.Err(err),
+			slog.String(logger.KeyErrorClass, class),
+		)
+		httpresp.Error(w, fmt.Sprintf("%s: %s", msg, err), status)
+		return
+	}
+	h.logger.LogAttrs(ctx, slog.LevelInfo, "crawl finished",
+		slog.Int(logger.KeyStatus, http.StatusOK),
+		slog.Duration(logger.KeyDuration, time.Since(start)),
+		slog.Int("urls", len(batch.Targets)),
+	)
+
+	// a client that has set a deadline must be able to tell finished urls from timed out ones
+	if req.extended || batch.Deadline > 0 || batch.Timings {
+		httpresp.WriteJSON(w, detailedResponse(data), http.StatusOK)
 		return
 	}
 
 	resp := make(CrawlResponse)
 	for k, v := range data {
-		resp[k] = string(v)
+		resp[k] = string(v.Data)
 	}
 	httpresp.WriteJSON(w, resp, http.StatusOK)
 }
+
+// clientName tells who sent the request: the client identity when clients
+// are identified, the remote address otherwise.
+func clientName(r *http.Request) string {
+	if client, ok := clientid.FromContext(r.Context()); ok {
+		return client.Key
+	}
+	return r.RemoteAddr
+}
+
+// batch builds a crawler batch from the request, applying the server timeout limits.
+func (h *HTTPHandler) batch(r *http.Request, req *CrawlRequest) (crawler.Batch, error) {
+	var batch crawler.Batch
+
+	if req.TimeoutMs < 0 {
+		return batch, errors.New("timeout_ms must be positive")
+	}
+	batch.Deadline = time.Duration(req.TimeoutMs) * time.Millisecond
+
+	if v := r.Header.Get(HeaderRequestTimeout); v != "" {
+		d, err := parseTimeout(v)
+		if err != nil {
+			return batch, fmt.Errorf("invalid %s header: %w", HeaderRequestTimeout, err)
+		}
+		// the header overrides the body, it is usually set by a proxy that knows the caller's budget
+		batch.Deadline = d
+	}
+	batch.Deadline = capDuration(batch.Deadline, h.maxBatchTimeout)
+
+	batch.Timings = req.Debug
+	if v := r.URL.Query().Get(queryDebug); v != "" {
+		debug, err := strconv.ParseBool(v)
+		if err != nil {
+			return batch, fmt.Errorf("invalid %s parameter: %w", queryDebug, err)
+		}
+		batch.Timings = debug
+	}
+
+	batch.Targets = make([]crawler.Target, len(req.URLs))
+	for i, u := range req.URLs {
+		if u.TimeoutMs < 0 {
+			return batch, fmt.Errorf("timeout_ms of %s must be positive", u.URL)
+		}
+		batch.Targets[i] = crawler.Target{
+			URL:     u.URL,
+			Timeout: capDuration(time.Duration(u.TimeoutMs)*time.Millisecond, h.maxRequestTimeout),
+		}
+	}
+
+	return batch, nil
+}
+
+func detailedResponse(data map[string]crawler.Result) CrawlDetailedResponse {
+	resp := make(CrawlDetailedResponse, len(data))
+	for k, v := range data {
+		resp[k] = NewCrawlURLResult(v)
+	}
+	return resp
+}
+
+// NewCrawlURLResult converts a crawler result to its entry of the detailed response.
+func NewCrawlURLResult(res crawler.Result) CrawlURLResult {
+	switch {
+	case errors.Is(res.Err, crawler.ErrTimedOut):
+		return CrawlURLResult{Status: statusTimeout, Error: res.Err.Error(), Timings: urlTimings(res.Timings)}
+	case res.Err != nil:
+		return CrawlURLResult{Status: statusError, Error: res.Err.Error(), Timings: urlTimings(res.Timings)}
+	default:
+		return CrawlURLResult{Status: statusOK, Body: string(res.Data), Timings: urlTimings(res.Timings)}
+	}
+}
+
+func urlTimings(t *crawler.Timings) *URLTimings {
+	if t == nil {
+		return nil
+	}
+	return &URLTimings{
+		DNSMs:          milliseconds(t.DNS),
+		ConnectMs:      milliseconds(t.Connect),
+		TLSHandshakeMs: milliseconds(t.TLSHandshake),
+		FirstByteMs:    milliseconds(t.FirstByte),
+		DownloadMs:     milliseconds(t.Download),
+		Reused:         t.Reused,
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// parseTimeout parses a timeout given in milliseconds or as a Go duration.
+func parseTimeout(s string) (time.Duration, error) {
+	var d time.Duration
+	if ms, err := strconv.Atoi(s); err == nil {
+		d = time.Duration(ms) * time.Millisecond
+	} else {
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, errors.New("timeout must be positive")
+	}
+	return d, nil
+}
+
+// capDuration limits d to limit, zero limit means no limit.
+func capDuration(d, limit time.Duration) time.Duration {
+	if limit > 0 && d > limit {
+		return limit
+	}
+	return d
+}
+
+// setAdmissionState advertises the URLs left in flight. They are no requests,
+// so they get headers of their own rather than the RateLimit ones.
+func (h *HTTPHandler) setAdmissionState(w http.ResponseWriter, rejected bool) {
+	s, ok := h.admission.(limiter.Stater)
+	if !ok {
+		return
+	}
+	st := s.State()
+	httpresp.SetURLsLimit(w, st.Limit, st.Remaining)
+	if rejected {
+		httpresp.SetRetryAfter(w, st.RetryAfter)
+	}
+}
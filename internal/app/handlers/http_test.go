@@ -5,29 +5,38 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/apoldev/go-http/internal/app/crawler"
 	"github.com/apoldev/go-http/internal/app/handlers"
 	"github.com/apoldev/go-http/internal/app/handlers/mocks"
+	"github.com/apoldev/go-http/internal/app/inflight"
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
 func TestCrawlHandler(t *testing.T) {
-	logger := log.New(io.Discard, "", log.LstdFlags)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	cases := []struct {
 		name            string
 		body            []byte
 		method          string
+		header          http.Header
 		needCallCrawler bool
 		urls            []string
+		batch           *crawler.Batch
 		expectedStatus  int
 		results         map[string][]byte
 		expectError     error
+		expectDetailed  handlers.CrawlDetailedResponse
 	}{
 		{
 			name:           "bad_method",
@@ -90,27 +99,78 @@ func TestCrawlHandler(t *testing.T) {
 			needCallCrawler: false,
 			expectedStatus:  http.StatusBadRequest,
 		},
+
+		{
+			name:            "extended_request",
+			method:          http.MethodPost,
+			body:            []byte(`{"urls":[{"url":"https://google.com","timeout_ms":300}],"timeout_ms":5000}`),
+			needCallCrawler: true,
+			batch: &crawler.Batch{
+				Targets:  []crawler.Target{{URL: "https://google.com", Timeout: 300 * time.Millisecond}},
+				Deadline: 2 * time.Second,
+			},
+			results:        map[string][]byte{"https://google.com": []byte("google")},
+			expectedStatus: http.StatusOK,
+			expectDetailed: handlers.CrawlDetailedResponse{
+				"https://google.com": {Status: "ok", Body: "google"},
+			},
+		},
+
+		{
+			name:            "header_timeout",
+			method:          http.MethodPost,
+			header:          http.Header{handlers.HeaderRequestTimeout: []string{"1500"}},
+			body:            []byte(`["https://google.com"]`),
+			needCallCrawler: true,
+			batch: &crawler.Batch{
+				Targets:  []crawler.Target{{URL: "https://google.com"}},
+				Deadline: 1500 * time.Millisecond,
+			},
+			results:        map[string][]byte{"https://google.com": nil},
+			expectedStatus: http.StatusOK,
+			expectDetailed: handlers.CrawlDetailedResponse{
+				"https://google.com": {Status: "timeout", Error: crawler.ErrTimedOut.Error()},
+			},
+		},
+
+		{
+			name:           "invalid_header_timeout",
+			method:         http.MethodPost,
+			header:         http.Header{handlers.HeaderRequestTimeout: []string{"soon"}},
+			body:           []byte(`["https://google.com"]`),
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := context.Background()
 			mockCrawler := mocks.NewService(t)
-			h := handlers.NewHTTPHandler(mockCrawler, 1, logger)
+			h := handlers.NewHTTPHandler(mockCrawler, 1, logger, handlers.WithTimeoutLimits(2*time.Second, time.Second))
 
 			if tc.needCallCrawler {
-				mockCrawler.On("Crawl", ctx, tc.urls).
-					Return(tc.results, tc.expectError).
+				mockCrawler.On("CrawlBatch", ctx, expectedBatch(tc.urls, tc.batch)).
+					Return(crawlResults(tc.results), tc.expectError).
 					Once()
 			}
 
 			req := httptest.NewRequest(tc.method, "/", bytes.NewReader(tc.body))
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
 			w := httptest.NewRecorder()
 			h.Crawl(w, req)
 			resp := w.Result()
 
 			require.Equal(t, tc.expectedStatus, resp.StatusCode)
 
+			if resp.StatusCode == http.StatusOK && tc.expectDetailed != nil {
+				var results handlers.CrawlDetailedResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+				require.Equal(t, tc.expectDetailed, results)
+				return
+			}
+
 			if resp.StatusCode == http.StatusOK {
 				b, err := io.ReadAll(resp.Body)
 				require.NoError(t, err)
@@ -126,3 +186,196 @@ func TestCrawlHandler(t *testing.T) {
 		})
 	}
 }
+
+func expectedBatch(urls []string, batch *crawler.Batch) crawler.Batch {
+	if batch != nil {
+		return *batch
+	}
+	b := crawler.Batch{Targets: make([]crawler.Target, len(urls))}
+	for i := range urls {
+		b.Targets[i] = crawler.Target{URL: urls[i]}
+	}
+	return b
+}
+
+// crawlResults converts fake bodies to crawler results, nil body means the url has timed out.
+func crawlResults(data map[string][]byte) map[string]crawler.Result {
+	if data == nil {
+		return nil
+	}
+	results := make(map[string]crawler.Result, len(data))
+	for url, body := range data {
+		if body == nil {
+			results[url] = crawler.Result{URL: url, Err: crawler.ErrTimedOut}
+			continue
+		}
+		results[url] = crawler.Result{URL: url, Data: body}
+	}
+	return results
+}
+
+func TestCrawlHandler_Admission(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	mockCrawler := mocks.NewService(t)
+	admission := limiter.NewWeighted(3)
+	h := handlers.NewHTTPHandler(mockCrawler, 20, logger, handlers.WithAdmission(admission))
+
+	// another request is fetching two urls
+	require.True(t, admission.TryAcquire(2))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`["https://google.com","https://yandex.ru"]`)))
+	w := httptest.NewRecorder()
+	// set by a request limiter in front of the handler
+	w.Header().Set("RateLimit-Limit", "10")
+	w.Header().Set("RateLimit-Remaining", "5")
+	h.Crawl(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	require.Equal(t, "3", w.Header().Get("RateLimit-URLs-Limit"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-URLs-Remaining"))
+	// urls and requests are not mixed up
+	require.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "5", w.Header().Get("RateLimit-Remaining"))
+	// a concurrency limit doesn't know when units are freed
+	require.Empty(t, w.Header().Get("Retry-After"))
+
+	mockCrawler.On("CrawlBatch", ctx, expectedBatch([]string{"https://google.com"}, nil)).
+		Return(crawlResults(map[string][]byte{"https://google.com": []byte("google")}), nil).
+		Once()
+
+	req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`["https://google.com"]`)))
+	w = httptest.NewRecorder()
+	h.Crawl(w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Equal(t, "0", w.Header().Get("RateLimit-URLs-Remaining"))
+	require.Empty(t, w.Header().Get("RateLimit-Remaining"))
+	require.Empty(t, w.Header().Get("Retry-After"))
+	require.Equal(t, int64(1), admission.Available(), "the request must give its units back")
+}
+
+func TestCrawlHandler_Debug(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	mockCrawler := mocks.NewService(t)
+	h := handlers.NewHTTPHandler(mockCrawler, 20, logger)
+
+	batch := crawler.Batch{Targets: []crawler.Target{{URL: "https://google.com"}}, Timings: true}
+	mockCrawler.On("CrawlBatch", ctx, batch).
+		Return(map[string]crawler.Result{"https://google.com": {
+			URL:  "https://google.com",
+			Data: []byte("google"),
+			Timings: &crawler.Timings{
+				DNS:       1500 * time.Microsecond,
+				FirstByte: 20 * time.Millisecond,
+				Download:  5 * time.Millisecond,
+				Reused:    false,
+			},
+		}}, nil).
+		Twice()
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/?debug=1", bytes.NewReader([]byte(`["https://google.com"]`))),
+		httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"urls":["https://google.com"],"debug":true}`))),
+	} {
+		w := httptest.NewRecorder()
+		h.Crawl(w, req)
+		require.Equal(t, http.StatusOK, w.Result().StatusCode)
+
+		var results handlers.CrawlDetailedResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		require.Equal(t, handlers.CrawlDetailedResponse{"https://google.com": {
+			Status: "ok",
+			Body:   "google",
+			Timings: &handlers.URLTimings{
+				DNSMs:       1.5,
+				FirstByteMs: 20,
+				DownloadMs:  5,
+			},
+		}}, results)
+	}
+
+	w := httptest.NewRecorder()
+	h.Crawl(w, httptest.NewRequest(http.MethodPost, "/?debug=maybe", bytes.NewReader([]byte(`["https://google.com"]`))))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+}
+
+func TestCrawlHandler_Inflight(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mockCrawler := mocks.NewService(t)
+	crawls := inflight.NewRegistry()
+	h := handlers.NewHTTPHandler(mockCrawler, 20, logger, handlers.WithInflight(crawls))
+	admin := handlers.NewAdminHandler(nil, nil, handlers.WithCrawls(crawls))
+
+	started := make(chan struct{})
+	mockCrawler.On("CrawlBatch", mock.Anything, expectedBatch([]string{"https://google.com"}, nil)).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(nil, context.Canceled).
+		Once()
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Crawl(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`["https://google.com"]`))))
+	}()
+	<-started
+
+	list := httptest.NewRecorder()
+	admin.Crawls(list, httptest.NewRequest(http.MethodGet, handlers.CrawlsPath, nil))
+	require.Equal(t, http.StatusOK, list.Code)
+	var running []inflight.Crawl
+	require.NoError(t, json.Unmarshal(list.Body.Bytes(), &running))
+	require.Len(t, running, 1)
+	require.Equal(t, 1, running[0].URLs)
+	require.Equal(t, "192.0.2.1:1234", running[0].Client)
+
+	cancel := httptest.NewRecorder()
+	admin.Crawls(cancel, httptest.NewRequest(http.MethodDelete, handlers.CrawlsPath+"/"+running[0].ID, nil))
+	require.Equal(t, http.StatusAccepted, cancel.Code)
+
+	<-done
+	require.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	require.Contains(t, w.Body.String(), inflight.ErrCancelled.Error())
+	require.Empty(t, crawls.List())
+
+	cancel = httptest.NewRecorder()
+	admin.Crawls(cancel, httptest.NewRequest(http.MethodDelete, handlers.CrawlsPath+"/"+running[0].ID, nil))
+	require.Equal(t, http.StatusNotFound, cancel.Code)
+}
+
+func TestCrawlHandler_SetMaxURLs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+	mockCrawler := mocks.NewService(t)
+	h := handlers.NewHTTPHandler(mockCrawler, 1, logger)
+	body := `["https://google.com","https://yandex.ru"]`
+
+	w := httptest.NewRecorder()
+	h.Crawl(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(body))))
+	require.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// the request now passes the max urls check and gets to the crawler
+	h.SetMaxURLs(2)
+	mockCrawler.On("CrawlBatch", ctx, expectedBatch([]string{"https://google.com", "https://yandex.ru"}, nil)).
+		Return(nil, fmt.Errorf("%w: yandex.ru", crawler.ErrHostNotAllowed)).
+		Once()
+
+	w = httptest.NewRecorder()
+	h.Crawl(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(body))))
+	require.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+}
+
+func TestNewCrawlURLResult_FailedTimings(t *testing.T) {
+	timings := &crawler.Timings{Connect: 2 * time.Millisecond}
+
+	res := handlers.NewCrawlURLResult(crawler.Result{URL: "https://google.com", Err: crawler.ErrTimedOut, Timings: timings})
+	require.Equal(t, "timeout", res.Status)
+	require.Equal(t, &handlers.URLTimings{ConnectMs: 2}, res.Timings)
+
+	res = handlers.NewCrawlURLResult(crawler.Result{URL: "https://google.com", Err: io.EOF, Timings: timings})
+	require.Equal(t, "error", res.Status)
+	require.Equal(t, &handlers.URLTimings{ConnectMs: 2}, res.Timings)
+}
@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 
+	crawler "github.com/apoldev/go-http/internal/app/crawler"
+
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -13,29 +15,29 @@ type Service struct {
 	mock.Mock
 }
 
-// Crawl provides a mock function with given fields: ctx, urls
-func (_m *Service) Crawl(ctx context.Context, urls []string) (map[string][]byte, error) {
-	ret := _m.Called(ctx, urls)
+// CrawlBatch provides a mock function with given fields: ctx, batch
+func (_m *Service) CrawlBatch(ctx context.Context, batch crawler.Batch) (map[string]crawler.Result, error) {
+	ret := _m.Called(ctx, batch)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Crawl")
+		panic("no return value specified for CrawlBatch")
 	}
 
-	var r0 map[string][]byte
+	var r0 map[string]crawler.Result
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, []string) (map[string][]byte, error)); ok {
-		return rf(ctx, urls)
+	if rf, ok := ret.Get(0).(func(context.Context, crawler.Batch) (map[string]crawler.Result, error)); ok {
+		return rf(ctx, batch)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, []string) map[string][]byte); ok {
-		r0 = rf(ctx, urls)
+	if rf, ok := ret.Get(0).(func(context.Context, crawler.Batch) map[string]crawler.Result); ok {
+		r0 = rf(ctx, batch)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(map[string][]byte)
+			r0 = ret.Get(0).(map[string]crawler.Result)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
-		r1 = rf(ctx, urls)
+	if rf, ok := ret.Get(1).(func(context.Context, crawler.Batch) error); ok {
+		r1 = rf(ctx, batch)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,111 @@
+// Package inflight keeps track of the crawls being served, so that operators
+// can see them and cancel runaway ones without restarting the process.
+package inflight
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+)
+
+// ErrCancelled is the cause of the context of a crawl cancelled through Cancel.
+var ErrCancelled = errors.New("crawl cancelled by operator")
+
+// Crawl describes a crawl in flight.
+type Crawl struct {
+	ID        string    `json:"id"`
+	RequestID string    `json:"request_id"`
+	Client    string    `json:"client"`
+	Start     time.Time `json:"start"`
+	URLs      int       `json:"urls"`
+	Completed int       `json:"completed"`
+	// Fetching are the URLs being fetched right now.
+	Fetching []string `json:"fetching"`
+}
+
+type entry struct {
+	crawl    Crawl
+	progress *crawler.Progress
+	cancel   context.CancelCauseFunc
+}
+
+func (e *entry) snapshot() Crawl {
+	c := e.crawl
+	c.Completed, c.Fetching = e.progress.Snapshot()
+	return c
+}
+
+// Registry is the set of crawls in flight.
+type Registry struct {
+	mu     sync.Mutex
+	crawls map[string]*entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{crawls: make(map[string]*entry)}
+}
+
+// Start registers a crawl of urls URLs. The crawl must run with the returned
+// context, which is cancelled by Cancel and follows the crawl's progress, and
+// call done once it has finished.
+func (r *Registry) Start(ctx context.Context, client string, urls int) (context.Context, func()) {
+	e := &entry{
+		crawl: Crawl{
+			ID:     requestid.New(),
+			Client: client,
+			Start:  time.Now(),
+			URLs:   urls,
+		},
+		progress: crawler.NewProgress(),
+	}
+	e.crawl.RequestID, _ = requestid.FromContext(ctx)
+
+	ctx, e.cancel = context.WithCancelCause(ctx)
+	ctx = crawler.WithProgress(ctx, e.progress)
+
+	r.mu.Lock()
+	r.crawls[e.crawl.ID] = e
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.crawls, e.crawl.ID)
+		r.mu.Unlock()
+		e.cancel(nil)
+	}
+}
+
+// List returns the crawls in flight, the oldest first.
+func (r *Registry) List() []Crawl {
+	r.mu.Lock()
+	entries := make([]*entry, 0, len(r.crawls))
+	for _, e := range r.crawls {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+
+	crawls := make([]Crawl, 0, len(entries))
+	for _, e := range entries {
+		crawls = append(crawls, e.snapshot())
+	}
+	sort.Slice(crawls, func(i, j int) bool { return crawls[i].Start.Before(crawls[j].Start) })
+	return crawls
+}
+
+// Cancel cancels the crawl with ErrCancelled. It reports false if there is no
+// such crawl in flight.
+func (r *Registry) Cancel(id string) (Crawl, bool) {
+	r.mu.Lock()
+	e, ok := r.crawls[id]
+	r.mu.Unlock()
+	if !ok {
+		return Crawl{}, false
+	}
+	e.cancel(ErrCancelled)
+	return e.snapshot(), true
+}
@@ -0,0 +1,117 @@
+package inflight_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/app/inflight"
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/stretchr/testify/require"
+)
+
+type instantTransport struct{}
+
+func (instantTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok")), Request: req}, nil
+}
+
+func TestRegistry_StartDone(t *testing.T) {
+	r := inflight.NewRegistry()
+	ctx, done := r.Start(requestid.NewContext(context.Background(), "req-1"), "ip:192.0.2.1", 3)
+
+	crawls := r.List()
+	require.Len(t, crawls, 1)
+	require.NotEmpty(t, crawls[0].ID)
+	require.Equal(t, "req-1", crawls[0].RequestID)
+	require.Equal(t, "ip:192.0.2.1", crawls[0].Client)
+	require.Equal(t, 3, crawls[0].URLs)
+	require.Zero(t, crawls[0].Completed)
+	require.NoError(t, ctx.Err())
+
+	done()
+	require.Empty(t, r.List())
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	require.NotErrorIs(t, context.Cause(ctx), inflight.ErrCancelled)
+
+	_, ok := r.Cancel(crawls[0].ID)
+	require.False(t, ok, "a finished crawl can't be cancelled")
+}
+
+func TestRegistry_Cancel(t *testing.T) {
+	r := inflight.NewRegistry()
+
+	_, ok := r.Cancel("unknown")
+	require.False(t, ok)
+
+	ctx, done := r.Start(context.Background(), "ip:192.0.2.1", 1)
+	defer done()
+	other, otherDone := r.Start(context.Background(), "ip:192.0.2.2", 1)
+	defer otherDone()
+
+	var id string
+	for _, c := range r.List() {
+		if c.Client == "ip:192.0.2.1" {
+			id = c.ID
+		}
+	}
+	crawl, ok := r.Cancel(id)
+	require.True(t, ok)
+	require.Equal(t, id, crawl.ID)
+	require.ErrorIs(t, context.Cause(ctx), inflight.ErrCancelled)
+	require.NoError(t, other.Err(), "only the cancelled crawl stops")
+
+	// the crawl is listed until it has finished
+	require.Len(t, r.List(), 2)
+}
+
+func TestRegistry_ListWhileCrawling(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service := crawler.New(2, 1000, &http.Client{Transport: instantTransport{}}, logger)
+	r := inflight.NewRegistry()
+
+	urls := make([]string, 10)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://host%d.example/", i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, done := r.Start(context.Background(), "ip:192.0.2.1", len(urls))
+			defer done()
+			if _, err := service.Crawl(ctx, urls); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	for {
+		select {
+		case <-finished:
+			require.Empty(t, r.List())
+			return
+		default:
+		}
+		crawls := r.List()
+		for i, c := range crawls {
+			require.LessOrEqual(t, c.Completed+len(c.Fetching), c.URLs)
+			if i > 0 {
+				require.False(t, c.Start.Before(crawls[i-1].Start), "the oldest crawl comes first")
+			}
+		}
+	}
+}
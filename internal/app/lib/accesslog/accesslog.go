@@ -0,0 +1,89 @@
+// Package accesslog carries the details of a request that only the handlers
+// know, e.g. the number of URLs, to the access log middleware.
+package accesslog
+
+import (
+	"context"
+	"sync"
+)
+
+// Outcome is what the limiters did to a request. A later outcome only replaces
+// a less severe one, so a request queued by one limiter and rejected by
+// another is logged as rejected.
+type Outcome int
+
+const (
+	OutcomeNone Outcome = iota
+	OutcomeAdmitted
+	OutcomeQueued
+	OutcomeRejected
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeAdmitted:
+		return "admitted"
+	case OutcomeQueued:
+		return "queued"
+	case OutcomeRejected:
+		return "rejected"
+	default:
+		return "-"
+	}
+}
+
+// Entry is filled in while the request is served. A nil *Entry is a no-op.
+type Entry struct {
+	mu      sync.Mutex
+	urls    int
+	limiter Outcome
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, ctxKey{}, e)
+}
+
+// FromContext returns the entry stored by NewContext, nil if there is none.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(ctxKey{}).(*Entry)
+	return e
+}
+
+func (e *Entry) SetURLs(n int) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.urls = n
+}
+
+func (e *Entry) URLs() int {
+	if e == nil {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.urls
+}
+
+// SetLimiter records the outcome unless a more severe one is already recorded.
+func (e *Entry) SetLimiter(o Outcome) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.limiter = max(e.limiter, o)
+}
+
+func (e *Entry) Limiter() Outcome {
+	if e == nil {
+		return OutcomeNone
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.limiter
+}
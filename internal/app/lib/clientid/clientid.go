@@ -0,0 +1,159 @@
+package clientid
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultAPIKeyHeader = "X-API-Key"
+	DefaultTier         = "default"
+
+	headerForwardedFor = "X-Forwarded-For"
+	// keyHashLen is the length of the API key hash used as identity, the key itself is never kept.
+	keyHashLen = 16
+)
+
+// Identity identifies a client for per-client limits.
+type Identity struct {
+	// Key is unique per client: "key:<hash>", "mtls:<subject>" or "ip:<address>".
+	Key string
+	// Tier is the limits tier of the client.
+	Tier string
+}
+
+type ctxKey struct{}
+
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the client identity stored by NewContext.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(ctxKey{}).(Identity)
+	return id, ok
+}
+
+// Identifier extracts the client identity from a request. A known API key wins,
+// then the mTLS client certificate subject, then the client IP. Unknown API keys
+// are ignored, otherwise a client could get fresh limits by inventing keys.
+type Identifier struct {
+	apiKeyHeader   string
+	trustedProxies []*net.IPNet
+	apiKeyTiers    map[string]string
+	subjectTiers   map[string]string
+}
+
+// NewIdentifier creates an Identifier. trustedProxies are IPs or CIDRs whose
+// X-Forwarded-For is honoured; apiKeyTiers and subjectTiers map API keys and
+// certificate subjects to tiers.
+func NewIdentifier(
+	apiKeyHeader string, trustedProxies []string, apiKeyTiers, subjectTiers map[string]string,
+) (*Identifier, error) {
+	if apiKeyHeader == "" {
+		apiKeyHeader = DefaultAPIKeyHeader
+	}
+
+	nets, err := ParseNets(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identifier{
+		apiKeyHeader:   apiKeyHeader,
+		trustedProxies: nets,
+		apiKeyTiers:    apiKeyTiers,
+		subjectTiers:   subjectTiers,
+	}, nil
+}
+
+func (i *Identifier) Identify(r *http.Request) Identity {
+	if key := r.Header.Get(i.apiKeyHeader); key != "" {
+		if tier, ok := i.apiKeyTiers[key]; ok {
+			sum := sha256.Sum256([]byte(key))
+			return Identity{Key: "key:" + hex.EncodeToString(sum[:])[:keyHashLen], Tier: tier}
+		}
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		subject := r.TLS.PeerCertificates[0].Subject.String()
+		tier, ok := i.subjectTiers[subject]
+		if !ok {
+			tier = DefaultTier
+		}
+		return Identity{Key: "mtls:" + subject, Tier: tier}
+	}
+
+	return Identity{Key: "ip:" + i.ClientIP(r), Tier: DefaultTier}
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is walked from the
+// right while the hops are trusted proxies, the first untrusted hop is the client.
+func (i *Identifier) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !i.trusted(ip) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values(headerForwardedFor), ","), ",")
+	for j := len(hops) - 1; j >= 0; j-- {
+		hop := strings.TrimSpace(hops[j])
+		hopIP := net.ParseIP(hop)
+		if hopIP == nil {
+			break
+		}
+		host = hop
+		if !i.trusted(hopIP) {
+			break
+		}
+	}
+	return host
+}
+
+func (i *Identifier) trusted(ip net.IP) bool {
+	for _, n := range i.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNets parses IPs and CIDRs, a plain IP is a single-address network.
+func ParseNets(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", v)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			bits := 8 * len(ip)
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
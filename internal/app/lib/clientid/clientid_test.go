@@ -0,0 +1,104 @@
+package clientid_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentifier_Identify(t *testing.T) {
+	identifier, err := clientid.NewIdentifier(
+		"",
+		[]string{"10.0.0.0/8", "192.168.1.1"},
+		map[string]string{"secret": "gold"},
+		map[string]string{"CN=billing": "silver"},
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		header       http.Header
+		subject      string
+		expectedKey  string
+		expectedTier string
+	}{
+		{
+			name:         "ip",
+			remoteAddr:   "1.2.3.4:5678",
+			expectedKey:  "ip:1.2.3.4",
+			expectedTier: clientid.DefaultTier,
+		},
+		{
+			name:         "untrusted_forwarded_for",
+			remoteAddr:   "1.2.3.4:5678",
+			header:       http.Header{"X-Forwarded-For": []string{"5.6.7.8"}},
+			expectedKey:  "ip:1.2.3.4",
+			expectedTier: clientid.DefaultTier,
+		},
+		{
+			name:         "trusted_proxies",
+			remoteAddr:   "10.0.0.1:5678",
+			header:       http.Header{"X-Forwarded-For": []string{"6.6.6.6, 5.6.7.8, 192.168.1.1"}},
+			expectedKey:  "ip:5.6.7.8",
+			expectedTier: clientid.DefaultTier,
+		},
+		{
+			name:         "api_key",
+			remoteAddr:   "1.2.3.4:5678",
+			header:       http.Header{"X-Api-Key": []string{"secret"}},
+			expectedKey:  "key:",
+			expectedTier: "gold",
+		},
+		{
+			name:         "unknown_api_key",
+			remoteAddr:   "1.2.3.4:5678",
+			header:       http.Header{"X-Api-Key": []string{"guess"}},
+			expectedKey:  "ip:1.2.3.4",
+			expectedTier: clientid.DefaultTier,
+		},
+		{
+			name:         "mtls",
+			remoteAddr:   "1.2.3.4:5678",
+			subject:      "billing",
+			expectedKey:  "mtls:CN=billing",
+			expectedTier: "silver",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			if tc.subject != "" {
+				req.TLS = &tls.ConnectionState{
+					PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: tc.subject}}},
+				}
+			}
+
+			id := identifier.Identify(req)
+			require.True(t, strings.HasPrefix(id.Key, tc.expectedKey), id.Key)
+			require.NotContains(t, id.Key, "secret")
+			require.Equal(t, tc.expectedTier, id.Tier)
+		})
+	}
+}
+
+func TestParseNets(t *testing.T) {
+	nets, err := clientid.ParseNets([]string{"10.0.0.0/8", "::1", " 1.2.3.4 ", ""})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+
+	_, err = clientid.ParseNets([]string{"localhost"})
+	require.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package httpresp
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit headers as in the IETF RateLimit header fields draft.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// Headers of the limit on the URLs in flight. It counts URLs rather than
+// requests, so it has headers of its own instead of the RateLimit ones.
+const (
+	HeaderURLsLimit     = "RateLimit-URLs-Limit"
+	HeaderURLsRemaining = "RateLimit-URLs-Remaining"
+)
+
+// SetRateLimit sets the RateLimit headers. When several limiters apply to a
+// request, the most restrictive one is advertised: headers with fewer remaining
+// requests are kept. A zero limit is a limiter without a state and sets
+// nothing, a zero reset is unknown and RateLimit-Reset is left out.
+func SetRateLimit(w http.ResponseWriter, limit, remaining int, reset time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	h := w.Header()
+	if v := h.Get(HeaderRateLimitRemaining); v != "" {
+		if cur, err := strconv.Atoi(v); err == nil && cur < remaining {
+			return
+		}
+	}
+	h.Set(HeaderRateLimitLimit, strconv.Itoa(limit))
+	h.Set(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	if reset > 0 {
+		h.Set(HeaderRateLimitReset, strconv.FormatInt(seconds(reset), 10))
+	} else {
+		h.Del(HeaderRateLimitReset)
+	}
+}
+
+// SetURLsLimit sets the headers of the limit on the URLs in flight. A zero
+// limit is a limiter without a state and sets nothing.
+func SetURLsLimit(w http.ResponseWriter, limit, remaining int) {
+	if limit <= 0 {
+		return
+	}
+	w.Header().Set(HeaderURLsLimit, strconv.Itoa(limit))
+	w.Header().Set(HeaderURLsRemaining, strconv.Itoa(remaining))
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds, rounded up. A
+// zero duration is unknown, e.g. of a concurrency limiter, and sets nothing.
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w.Header().Set(HeaderRetryAfter, strconv.FormatInt(seconds(d), 10))
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
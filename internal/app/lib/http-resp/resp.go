@@ -3,6 +3,8 @@ package httpresp
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
 )
 
 // WriteJSON writes data to the response as JSON.
@@ -18,8 +20,12 @@ func WriteJSON(w http.ResponseWriter, data interface{}, code int) {
 	w.Write(bytes) //nolint:errcheck // ignore
 }
 
-// Error writes an error to the response as string.
+// Error writes an error to the response as string. The request ID, if the
+// response has one, is appended so that users can quote it in bug reports.
 func Error(w http.ResponseWriter, s string, code int) {
+	if id := w.Header().Get(requestid.Header); id != "" {
+		s += " (request id: " + id + ")"
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(code)
 	w.Write([]byte(s)) //nolint:errcheck // ignore
@@ -0,0 +1,40 @@
+package requestid_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	a, b := requestid.New(), requestid.New()
+	require.Len(t, a, 32)
+	require.NotEqual(t, a, b)
+	require.True(t, requestid.Valid(a))
+}
+
+func TestValid(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"abc-123":                true,
+		"":                       false,
+		"with space":             false,
+		"line\nbreak":            false,
+		"ünicode":                false,
+		strings.Repeat("a", 128): true,
+		strings.Repeat("a", 129): false,
+	} {
+		require.Equal(t, valid, requestid.Valid(id), id)
+	}
+}
+
+func TestContext(t *testing.T) {
+	_, ok := requestid.FromContext(context.Background())
+	require.False(t, ok)
+
+	id, ok := requestid.FromContext(requestid.NewContext(context.Background(), "abc"))
+	require.True(t, ok)
+	require.Equal(t, "abc", id)
+}
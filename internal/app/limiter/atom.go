@@ -1,14 +1,19 @@
 package limiter
 
-import "sync/atomic"
+import (
+	"context"
+	"sync/atomic"
+)
 
 type AtomLimiter struct {
-	limit int32
+	limit    int32
+	capacity int32
 }
 
 func NewAtomLimiter(limit int) *AtomLimiter {
 	return &AtomLimiter{
-		limit: int32(limit),
+		limit:    int32(limit),
+		capacity: int32(limit),
 	}
 }
 
@@ -23,3 +28,28 @@ func (c *AtomLimiter) Take() bool {
 func (c *AtomLimiter) Release() {
 	atomic.AddInt32(&c.limit, 1)
 }
+
+// Acquire takes a slot without waiting, it fails with ErrLimitExceeded when none is free.
+func (c *AtomLimiter) Acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !c.Take() {
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// SetLimit changes the capacity. The free slots may go negative when it is
+// lowered below the slots in use, Take fails until enough of them are released.
+func (c *AtomLimiter) SetLimit(n int) {
+	old := atomic.SwapInt32(&c.capacity, int32(n))
+	atomic.AddInt32(&c.limit, int32(n)-old)
+}
+
+func (c *AtomLimiter) State() State {
+	return State{
+		Limit:     int(atomic.LoadInt32(&c.capacity)),
+		Remaining: max(int(atomic.LoadInt32(&c.limit)), 0),
+	}
+}
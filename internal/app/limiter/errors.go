@@ -0,0 +1,16 @@
+package limiter
+
+import "errors"
+
+var (
+	// ErrLimitExceeded is returned by limiters that don't wait when no slot is free.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrQueueFull is returned when the wait queue has no room for another request.
+	ErrQueueFull = errors.New("wait queue is full")
+	// ErrWaitTimeout is returned when no slot has been freed within the max wait time.
+	ErrWaitTimeout = errors.New("wait timeout")
+	// ErrShed is returned to a waiting request pushed out of the queue by a more important one.
+	ErrShed = errors.New("shed for higher priority request")
+	// ErrOverloaded is returned by LoadShedder while the process is over one of its thresholds.
+	ErrOverloaded = errors.New("overloaded")
+)
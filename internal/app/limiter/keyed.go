@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Keyed keeps a limiter per key, e.g. per client. Keys idle for longer than
+// idleTTL are dropped, and the least recently used key is dropped when there are
+// more than maxKeys of them. A dropped key starts afresh on its next request.
+// Keys holding permits are never dropped, or their client would get a fresh
+// limiter while its requests are in flight; while all keys are busy there can
+// be more than maxKeys of them.
+type Keyed struct {
+	mu      sync.Mutex
+	maxKeys int
+	idleTTL time.Duration
+	lru     list.List
+	items   map[string]*list.Element
+}
+
+// keyedEntry is the limiter of a key, as returned by Keyed.Get. It counts the
+// permits held, so that a busy key isn't evicted.
+type keyedEntry struct {
+	key      string
+	limiter  Limiter
+	lastUsed time.Time
+	inFlight atomic.Int64
+}
+
+func (e *keyedEntry) Take() bool {
+	if !e.limiter.Take() {
+		return false
+	}
+	e.inFlight.Add(1)
+	return true
+}
+
+func (e *keyedEntry) Release() {
+	e.limiter.Release()
+	e.inFlight.Add(-1)
+}
+
+// State is the state of the key's limiter, the zero State when it has none.
+func (e *keyedEntry) State() State {
+	if s, ok := e.limiter.(Stater); ok {
+		return s.State()
+	}
+	return State{}
+}
+
+func NewKeyed(maxKeys int, idleTTL time.Duration) *Keyed {
+	return &Keyed{
+		maxKeys: maxKeys,
+		idleTTL: idleTTL,
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// Get returns the limiter of the key, creating it with create on first use.
+func (k *Keyed) Get(key string, create func() Limiter) Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	k.evictIdle(now)
+
+	if el, ok := k.items[key]; ok {
+		k.lru.MoveToFront(el)
+		entry := entryOf(el)
+		entry.lastUsed = now
+		return entry
+	}
+
+	entry := &keyedEntry{key: key, limiter: create(), lastUsed: now}
+	front := k.lru.PushFront(entry)
+	k.items[key] = front
+	if k.maxKeys > 0 && k.lru.Len() > k.maxKeys {
+		// the least recently used idle key, never the one just added
+		for el := k.lru.Back(); el != front; el = el.Prev() {
+			if entryOf(el).inFlight.Load() == 0 {
+				k.remove(el)
+				break
+			}
+		}
+	}
+	return entry
+}
+
+// Len returns the number of tracked keys.
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lru.Len()
+}
+
+func (k *Keyed) evictIdle(now time.Time) {
+	if k.idleTTL <= 0 {
+		return
+	}
+	for el := k.lru.Back(); el != nil && now.Sub(entryOf(el).lastUsed) > k.idleTTL; {
+		prev := el.Prev()
+		if entryOf(el).inFlight.Load() == 0 {
+			k.remove(el)
+		}
+		el = prev
+	}
+}
+
+func (k *Keyed) remove(el *list.Element) {
+	k.lru.Remove(el)
+	delete(k.items, entryOf(el).key)
+}
+
+func entryOf(el *list.Element) *keyedEntry {
+	entry, _ := el.Value.(*keyedEntry)
+	return entry
+}
+
+// Chain takes a permit from every limiter in order and releases them all
+// together. Limiters that are cheap to undo, such as concurrency limiters,
+// should go first: a rate limiter token taken before a later failure is lost.
+type Chain struct {
+	limiters []Limiter
+}
+
+func NewChain(limiters ...Limiter) *Chain {
+	return &Chain{limiters: limiters}
+}
+
+func (c *Chain) Take() bool {
+	for i, l := range c.limiters {
+		if !l.Take() {
+			for j := i - 1; j >= 0; j-- {
+				c.limiters[j].Release()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Chain) Release() {
+	for i := len(c.limiters) - 1; i >= 0; i-- {
+		c.limiters[i].Release()
+	}
+}
+
+// State reports the most restrictive state of the chained limiters, the zero
+// State when none of them is a Stater.
+func (c *Chain) State() State {
+	var st State
+	first := true
+	for _, l := range c.limiters {
+		s, ok := l.(Stater)
+		if !ok {
+			continue
+		}
+		if first {
+			st, first = s.State(), false
+			continue
+		}
+		st = mostRestrictive(st, s.State())
+	}
+	return st
+}
@@ -1,5 +1,7 @@
 package limiter
 
+import "context"
+
 type ChanLimiter struct {
 	ch chan struct{}
 }
@@ -22,3 +24,23 @@ func (c *ChanLimiter) Take() bool {
 func (c *ChanLimiter) Release() {
 	<-c.ch
 }
+
+// Acquire waits for a free slot until ctx is done.
+func (c *ChanLimiter) Acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case c.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *ChanLimiter) State() State {
+	return State{
+		Limit:     cap(c.ch),
+		Remaining: cap(c.ch) - len(c.ch),
+	}
+}
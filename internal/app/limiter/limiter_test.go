@@ -1,6 +1,8 @@
 package limiter_test
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -42,6 +44,13 @@ func TestLimiter(t *testing.T) {
 			count:              10,
 			expectedStatusesOK: 5,
 		},
+
+		{
+			name:               "queue_limiter_5",
+			limiter:            limiter.NewQueueLimiter(5, 10, time.Second),
+			count:              10,
+			expectedStatusesOK: 5,
+		},
 	}
 
 	for _, c := range cases {
@@ -77,6 +86,342 @@ func TestLimiter(t *testing.T) {
 	}
 }
 
+func TestQueueLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waits_for_slot", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 1, time.Second)
+		require.NoError(t, l.Acquire(context.Background()))
+
+		time.AfterFunc(50*time.Millisecond, l.Release)
+		require.NoError(t, l.Acquire(context.Background()))
+	})
+
+	t.Run("queue_full", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 1, time.Second)
+		require.NoError(t, l.Acquire(context.Background()))
+
+		go l.Acquire(context.Background()) //nolint:errcheck // fills the queue
+		require.Eventually(t, func() bool {
+			return errors.Is(l.Acquire(context.Background()), limiter.ErrQueueFull)
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("wait_timeout", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 1, 20*time.Millisecond)
+		require.NoError(t, l.Acquire(context.Background()))
+		require.ErrorIs(t, l.Acquire(context.Background()), limiter.ErrWaitTimeout)
+
+		// the timed out waiter must leave the queue
+		l.Release()
+		require.True(t, l.Take())
+	})
+
+	t.Run("client_cancel", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 1, time.Second)
+		require.NoError(t, l.Acquire(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, l.Acquire(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 10, time.Second)
+		require.NoError(t, l.Acquire(context.Background()))
+
+		order := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func(i int) {
+				if l.Acquire(context.Background()) == nil {
+					order <- i
+				}
+			}(i)
+			// let the waiter join the queue before the next one
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		for i := 0; i < 3; i++ {
+			l.Release()
+			require.Equal(t, i, <-order)
+		}
+	})
+}
+
+type rateLimiterI interface {
+	limiterI
+	Acquire(ctx context.Context) error
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		limiter func(limit int, window time.Duration) rateLimiterI
+	}{
+		{
+			name: "token_bucket",
+			limiter: func(limit int, window time.Duration) rateLimiterI {
+				return limiter.NewTokenBucket(limit, window, limit)
+			},
+		},
+		{
+			name: "sliding_window_log",
+			limiter: func(limit int, window time.Duration) rateLimiterI {
+				return limiter.NewSlidingWindowLog(limit, window)
+			},
+		},
+		{
+			name: "sliding_window_counter",
+			limiter: func(limit int, window time.Duration) rateLimiterI {
+				return limiter.NewSlidingWindowCounter(limit, window)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name+"/take", func(t *testing.T) {
+			t.Parallel()
+			l := c.limiter(5, time.Minute)
+
+			var count int
+			for i := 0; i < 10; i++ {
+				if l.Take() {
+					count++
+					// releasing must not give the permit back
+					l.Release()
+				}
+			}
+			require.Equal(t, 5, count)
+		})
+
+		t.Run(c.name+"/refill", func(t *testing.T) {
+			t.Parallel()
+			l := c.limiter(2, 50*time.Millisecond)
+			require.True(t, l.Take())
+			require.True(t, l.Take())
+			require.False(t, l.Take())
+
+			require.Eventually(t, l.Take, time.Second, 10*time.Millisecond)
+		})
+
+		t.Run(c.name+"/acquire", func(t *testing.T) {
+			t.Parallel()
+			l := c.limiter(1, 50*time.Millisecond)
+			require.NoError(t, l.Acquire(context.Background()))
+
+			start := time.Now()
+			require.NoError(t, l.Acquire(context.Background()))
+			require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			require.ErrorIs(t, l.Acquire(ctx), context.Canceled)
+		})
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	t.Parallel()
+
+	create := func() limiter.Limiter {
+		return limiter.NewAtomLimiter(1)
+	}
+
+	t.Run("per_key", func(t *testing.T) {
+		k := limiter.NewKeyed(10, time.Minute)
+		require.True(t, k.Get("a", create).Take())
+		require.False(t, k.Get("a", create).Take())
+		require.True(t, k.Get("b", create).Take())
+		require.Equal(t, 2, k.Len())
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		k := limiter.NewKeyed(2, time.Minute)
+		a := k.Get("a", create)
+		k.Get("b", create)
+		require.Same(t, a, k.Get("a", create))
+
+		// b is the least recently used one
+		k.Get("c", create)
+		require.Equal(t, 2, k.Len())
+		require.Same(t, a, k.Get("a", create))
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		k := limiter.NewKeyed(10, 20*time.Millisecond)
+		a := k.Get("a", create)
+		time.Sleep(30 * time.Millisecond)
+		k.Get("b", create)
+		require.Equal(t, 1, k.Len())
+		require.NotSame(t, a, k.Get("a", create))
+	})
+
+	t.Run("busy_keys_are_kept", func(t *testing.T) {
+		k := limiter.NewKeyed(1, 20*time.Millisecond)
+		a := k.Get("a", create)
+		require.True(t, a.Take())
+
+		// neither over maxKeys nor idle for longer than the TTL
+		k.Get("b", create)
+		time.Sleep(30 * time.Millisecond)
+		k.Get("c", create)
+		require.Same(t, a, k.Get("a", create))
+		require.False(t, k.Get("a", create).Take(), "the client must not get a fresh limiter")
+
+		a.Release()
+		time.Sleep(30 * time.Millisecond)
+		k.Get("b", create)
+		require.NotSame(t, a, k.Get("a", create))
+	})
+}
+
+// unlimited is a limiter without a state.
+type unlimited struct{}
+
+func (unlimited) Take() bool { return true }
+func (unlimited) Release()   {}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	conc := limiter.NewAtomLimiter(2)
+	rate := limiter.NewSlidingWindowLog(1, time.Minute)
+	chain := limiter.NewChain(conc, rate)
+
+	require.True(t, chain.Take())
+	require.False(t, chain.Take())
+
+	// the concurrency slot taken by the failed attempt has been returned
+	require.True(t, conc.Take())
+	require.False(t, conc.Take())
+}
+
+func TestWeighted(t *testing.T) {
+	t.Parallel()
+
+	t.Run("try_acquire", func(t *testing.T) {
+		t.Parallel()
+		w := limiter.NewWeighted(20)
+		require.True(t, w.TryAcquire(15))
+		require.False(t, w.TryAcquire(6))
+		require.True(t, w.TryAcquire(5))
+		w.Release(15)
+		require.Equal(t, int64(15), w.Available())
+	})
+
+	t.Run("too_large", func(t *testing.T) {
+		t.Parallel()
+		w := limiter.NewWeighted(5)
+		require.ErrorIs(t, w.Acquire(context.Background(), 6), limiter.ErrLimitExceeded)
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		t.Parallel()
+		w := limiter.NewWeighted(10)
+		require.True(t, w.TryAcquire(10))
+
+		heavy := make(chan error, 1)
+		go func() { heavy <- w.Acquire(context.Background(), 8) }()
+		// let the heavy request join the queue
+		time.Sleep(20 * time.Millisecond)
+
+		// a light request can't jump ahead of the waiting heavy one
+		w.Release(5)
+		require.False(t, w.TryAcquire(1))
+		w.Release(5)
+		require.NoError(t, <-heavy)
+		require.Equal(t, int64(2), w.Available())
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		t.Parallel()
+		w := limiter.NewWeighted(10)
+		require.True(t, w.TryAcquire(5))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, w.Acquire(ctx, 8), context.DeadlineExceeded)
+		require.True(t, w.TryAcquire(5), "a cancelled waiter must not block others")
+	})
+}
+
+func TestPriorityLimiter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("reserved_capacity", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewPriorityLimiter(4, 1, 1, 0, time.Second)
+
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityLow))
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityLow))
+		require.ErrorIs(t, l.Acquire(ctx, limiter.PriorityLow), limiter.ErrQueueFull)
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityNormal))
+		require.ErrorIs(t, l.Acquire(ctx, limiter.PriorityNormal), limiter.ErrQueueFull)
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityHigh))
+		require.ErrorIs(t, l.Acquire(ctx, limiter.PriorityHigh), limiter.ErrQueueFull)
+	})
+
+	t.Run("high_served_first", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewPriorityLimiter(1, 0, 0, 10, time.Second)
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityLow))
+
+		order := make(chan limiter.Priority, 3)
+		for _, p := range []limiter.Priority{limiter.PriorityLow, limiter.PriorityNormal, limiter.PriorityHigh} {
+			go func(p limiter.Priority) {
+				if l.Acquire(ctx, p) == nil {
+					order <- p
+				}
+			}(p)
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		for _, p := range []limiter.Priority{limiter.PriorityHigh, limiter.PriorityNormal, limiter.PriorityLow} {
+			l.Release()
+			require.Equal(t, p, <-order)
+		}
+	})
+
+	t.Run("shed_low", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewPriorityLimiter(1, 0, 0, 1, time.Second)
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityNormal))
+
+		low := make(chan error, 1)
+		go func() { low <- l.Acquire(ctx, limiter.PriorityLow) }()
+		time.Sleep(20 * time.Millisecond)
+
+		high := make(chan error, 1)
+		go func() { high <- l.Acquire(ctx, limiter.PriorityHigh) }()
+		require.ErrorIs(t, <-low, limiter.ErrShed)
+
+		// the queue is full of more important requests
+		require.ErrorIs(t, l.Acquire(ctx, limiter.PriorityLow), limiter.ErrQueueFull)
+
+		l.Release()
+		require.NoError(t, <-high)
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		t.Parallel()
+		p, err := limiter.ParsePriority("HIGH")
+		require.NoError(t, err)
+		require.Equal(t, limiter.PriorityHigh, p)
+
+		_, err = limiter.ParsePriority("urgent")
+		require.Error(t, err)
+	})
+}
+
 func BenchmarkChanAtom(b *testing.B) {
 	cases := []struct {
 		name    string
@@ -110,3 +455,190 @@ func BenchmarkChanAtom(b *testing.B) {
 		})
 	}
 }
+
+func TestState(t *testing.T) {
+	t.Parallel()
+
+	t.Run("concurrency", func(t *testing.T) {
+		t.Parallel()
+		for name, l := range map[string]interface {
+			limiter.Limiter
+			limiter.Stater
+		}{
+			"atom":  limiter.NewAtomLimiter(3),
+			"chan":  limiter.NewChanLimiter(3),
+			"queue": limiter.NewQueueLimiter(3, 1, time.Second),
+		} {
+			require.True(t, l.Take(), name)
+			require.Equal(t, limiter.State{Limit: 3, Remaining: 2}, l.State(), name)
+			l.Release()
+			require.Equal(t, limiter.State{Limit: 3, Remaining: 3}, l.State(), name)
+		}
+	})
+
+	t.Run("token_bucket", func(t *testing.T) {
+		t.Parallel()
+		b := limiter.NewTokenBucket(1, time.Minute, 2)
+		require.Equal(t, 2, b.State().Remaining)
+		require.True(t, b.Take())
+		require.True(t, b.Take())
+
+		st := b.State()
+		require.Equal(t, 2, st.Limit)
+		require.Equal(t, 0, st.Remaining)
+		require.InDelta(t, time.Minute, st.RetryAfter, float64(time.Second))
+		require.InDelta(t, 2*time.Minute, st.Reset, float64(time.Second))
+	})
+
+	t.Run("sliding_window_log", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewSlidingWindowLog(2, time.Minute)
+		require.Equal(t, limiter.State{Limit: 2, Remaining: 2}, l.State())
+		require.True(t, l.Take())
+		require.True(t, l.Take())
+
+		st := l.State()
+		require.Equal(t, 0, st.Remaining)
+		require.InDelta(t, time.Minute, st.RetryAfter, float64(time.Second))
+		require.InDelta(t, time.Minute, st.Reset, float64(time.Second))
+	})
+
+	t.Run("sliding_window_counter", func(t *testing.T) {
+		t.Parallel()
+		c := limiter.NewSlidingWindowCounter(2, time.Hour)
+		require.True(t, c.Take())
+
+		st := c.State()
+		require.Equal(t, 1, st.Remaining)
+		require.Zero(t, st.RetryAfter)
+		require.True(t, c.Take())
+		require.Positive(t, c.State().RetryAfter)
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		t.Parallel()
+		c := limiter.NewChain(limiter.NewAtomLimiter(5), limiter.NewTokenBucket(1, time.Minute, 1))
+		require.True(t, c.Take())
+
+		st := c.State()
+		require.Equal(t, 1, st.Limit, "the empty bucket is the most restrictive")
+		require.Equal(t, 0, st.Remaining)
+		require.Positive(t, st.RetryAfter)
+	})
+
+	t.Run("chain_without_staters", func(t *testing.T) {
+		t.Parallel()
+		c := limiter.NewChain(unlimited{})
+		require.Zero(t, c.State())
+	})
+}
+
+func TestLoadShedder(t *testing.T) {
+	t.Parallel()
+
+	t.Run("goroutines", func(t *testing.T) {
+		t.Parallel()
+		s := limiter.NewLoadShedder(limiter.ShedConfig{MaxGoroutines: 1})
+		require.ErrorIs(t, s.Check(), limiter.ErrOverloaded)
+
+		s = limiter.NewLoadShedder(limiter.ShedConfig{MaxGoroutines: 1 << 20, MaxHeapBytes: 1 << 40})
+		require.NoError(t, s.Check())
+	})
+
+	t.Run("heap", func(t *testing.T) {
+		t.Parallel()
+		s := limiter.NewLoadShedder(limiter.ShedConfig{MaxHeapBytes: 1})
+		require.ErrorIs(t, s.Check(), limiter.ErrOverloaded)
+	})
+
+	t.Run("queue_latency", func(t *testing.T) {
+		t.Parallel()
+		interval := 20 * time.Millisecond
+		s := limiter.NewLoadShedder(limiter.ShedConfig{MaxQueueLatency: 100 * time.Millisecond, Interval: interval})
+		require.NoError(t, s.Check())
+
+		s.ObserveQueueLatency(50 * time.Millisecond)
+		s.ObserveQueueLatency(200 * time.Millisecond)
+		require.NoError(t, s.Check(), "signals are sampled once per interval")
+
+		time.Sleep(interval)
+		require.ErrorIs(t, s.Check(), limiter.ErrOverloaded)
+		require.ErrorIs(t, s.Check(), limiter.ErrOverloaded)
+
+		// no long waits during the last interval
+		time.Sleep(interval)
+		require.NoError(t, s.Check())
+	})
+}
+
+func TestSetLimit(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("atom", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewAtomLimiter(2)
+		require.True(t, l.Take())
+		require.True(t, l.Take())
+
+		// the slots in use are kept, new requests wait for them to be released
+		l.SetLimit(1)
+		require.Equal(t, limiter.State{Limit: 1, Remaining: 0}, l.State())
+		l.Release()
+		require.False(t, l.Take())
+		l.Release()
+		require.True(t, l.Take())
+
+		l.SetLimit(3)
+		require.True(t, l.Take())
+		require.True(t, l.Take())
+		require.False(t, l.Take())
+	})
+
+	t.Run("queue_raised", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(1, 10, time.Second)
+		require.NoError(t, l.Acquire(ctx))
+
+		acquired := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { acquired <- l.Acquire(ctx) }()
+		}
+		// let the waiters join the queue
+		time.Sleep(20 * time.Millisecond)
+
+		// the waiters are admitted without waiting for a release
+		l.SetLimit(3)
+		require.NoError(t, <-acquired)
+		require.NoError(t, <-acquired)
+		require.Equal(t, limiter.State{Limit: 3, Remaining: 0}, l.State())
+	})
+
+	t.Run("queue_lowered", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewQueueLimiter(2, 10, 50*time.Millisecond)
+		require.NoError(t, l.Acquire(ctx))
+		require.NoError(t, l.Acquire(ctx))
+
+		l.SetLimit(1)
+		l.Release()
+		require.ErrorIs(t, l.Acquire(ctx), limiter.ErrWaitTimeout)
+		l.Release()
+		require.NoError(t, l.Acquire(ctx))
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		t.Parallel()
+		l := limiter.NewPriorityLimiter(2, 1, 0, 10, 100*time.Millisecond)
+		require.NoError(t, l.Acquire(ctx, limiter.PriorityNormal))
+
+		acquired := make(chan error, 1)
+		go func() { acquired <- l.Acquire(ctx, limiter.PriorityNormal) }()
+		time.Sleep(20 * time.Millisecond)
+
+		// the reserved slot stays reserved
+		l.SetLimit(3)
+		require.NoError(t, <-acquired)
+		require.ErrorIs(t, l.Acquire(ctx, limiter.PriorityNormal), limiter.ErrWaitTimeout)
+	})
+}
@@ -0,0 +1,232 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+
+	numPriorities = 3
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+}
+
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(s) {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+	}
+}
+
+// PriorityLimiter is a concurrency limiter with a wait queue per priority.
+// Part of the capacity is reserved: reservedHigh slots are used only by high
+// priority requests and reservedNormal more slots only by normal and high ones,
+// so that high priority traffic gets through when the server is saturated.
+// Freed slots go to the highest priority waiters first, and when the queue is
+// full the newest waiter of the lowest priority is shed for a more important one.
+type PriorityLimiter struct {
+	mu             sync.Mutex
+	limits         [numPriorities]int
+	reservedHigh   int
+	reservedNormal int
+	inUse          int
+	queueSize      int
+	queued         int
+	maxWait        time.Duration
+	queues         [numPriorities]list.List
+}
+
+type priorityWaiter struct {
+	// done gets nil when the slot is granted or ErrShed when the waiter is shed.
+	done   chan error
+	elem   *list.Element
+	queued bool
+}
+
+func NewPriorityLimiter(capacity, reservedHigh, reservedNormal, queueSize int, maxWait time.Duration) *PriorityLimiter {
+	l := &PriorityLimiter{
+		reservedHigh:   reservedHigh,
+		reservedNormal: reservedNormal,
+		queueSize:      queueSize,
+		maxWait:        maxWait,
+	}
+	l.setLimits(capacity)
+	return l
+}
+
+func (l *PriorityLimiter) setLimits(capacity int) {
+	l.limits[PriorityHigh] = capacity
+	l.limits[PriorityNormal] = max(capacity-l.reservedHigh, 0)
+	l.limits[PriorityLow] = max(capacity-l.reservedHigh-l.reservedNormal, 0)
+}
+
+// SetLimit changes the capacity, the reserved slots stay as they are.
+func (l *PriorityLimiter) SetLimit(capacity int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setLimits(capacity)
+	l.grantLocked()
+}
+
+// Take takes a slot for a normal priority request without waiting.
+func (l *PriorityLimiter) Take() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.canAdmit(PriorityNormal) {
+		l.inUse++
+		return true
+	}
+	return false
+}
+
+// Acquire takes a slot for a request of priority p, waiting in the queue when
+// none is free. Besides the QueueLimiter errors it fails with ErrShed when the
+// request has been pushed out of the queue by a more important one.
+func (l *PriorityLimiter) Acquire(ctx context.Context, p Priority) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p = min(max(p, PriorityLow), PriorityHigh)
+
+	l.mu.Lock()
+	if l.canAdmit(p) {
+		l.inUse++
+		l.mu.Unlock()
+		return nil
+	}
+	if l.queued >= l.queueSize && !l.shedBelow(p) {
+		l.mu.Unlock()
+		return ErrQueueFull
+	}
+	w := &priorityWaiter{done: make(chan error, 1), queued: true}
+	w.elem = l.queues[p].PushBack(w)
+	l.queued++
+	l.mu.Unlock()
+	notifyQueued(ctx)
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-w.done:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrWaitTimeout
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.queued {
+		l.dequeue(p, w)
+		return err
+	}
+	if shed := <-w.done; shed != nil {
+		// shed while giving up, being pushed out is the real reason
+		return shed
+	}
+	// the slot was granted while giving up, pass it on
+	l.releaseLocked()
+	return err
+}
+
+func (l *PriorityLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *PriorityLimiter) releaseLocked() {
+	l.inUse--
+	l.grantLocked()
+}
+
+// grantLocked hands the free slots to the highest priority waiters.
+func (l *PriorityLimiter) grantLocked() {
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		for l.queues[p].Len() > 0 && l.inUse < l.limits[p] {
+			w := waiterOf(l.queues[p].Front())
+			l.dequeue(p, w)
+			l.inUse++
+			w.done <- nil
+		}
+	}
+}
+
+// canAdmit reports whether a request of priority p may take a slot now. It may
+// overtake lower priority waiters but never waiters of its own or higher priority.
+func (l *PriorityLimiter) canAdmit(p Priority) bool {
+	if l.inUse >= l.limits[p] {
+		return false
+	}
+	for q := p; q <= PriorityHigh; q++ {
+		if l.queues[q].Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shedBelow drops the newest waiter of the lowest priority below p.
+func (l *PriorityLimiter) shedBelow(p Priority) bool {
+	for q := PriorityLow; q < p; q++ {
+		if back := l.queues[q].Back(); back != nil {
+			w := waiterOf(back)
+			l.dequeue(q, w)
+			w.done <- ErrShed
+			return true
+		}
+	}
+	return false
+}
+
+func (l *PriorityLimiter) dequeue(p Priority, w *priorityWaiter) {
+	l.queues[p].Remove(w.elem)
+	w.queued = false
+	l.queued--
+}
+
+// State reports the capacity available to normal priority requests.
+func (l *PriorityLimiter) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return State{
+		Limit:     l.limits[PriorityNormal],
+		Remaining: max(l.limits[PriorityNormal]-l.inUse, 0),
+	}
+}
+
+func waiterOf(el *list.Element) *priorityWaiter {
+	w, _ := el.Value.(*priorityWaiter)
+	return w
+}
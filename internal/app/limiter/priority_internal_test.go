@@ -0,0 +1,34 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityLimiter_ShedWhileGivingUp(t *testing.T) {
+	t.Parallel()
+	l := NewPriorityLimiter(1, 0, 0, 1, time.Minute)
+	require.NoError(t, l.Acquire(context.Background(), PriorityNormal))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	low := make(chan error, 1)
+	go func() { low <- l.Acquire(ctx, PriorityLow) }()
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.queued == 1
+	}, time.Second, time.Millisecond)
+
+	// the waiter gives up and, before it takes the lock, is shed
+	l.mu.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	require.True(t, l.shedBelow(PriorityHigh))
+	l.mu.Unlock()
+
+	require.ErrorIs(t, <-low, ErrShed)
+	require.Equal(t, State{Limit: 1, Remaining: 0}, l.State())
+}
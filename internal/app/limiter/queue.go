@@ -0,0 +1,148 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// QueueLimiter is a concurrency limiter with a bounded FIFO wait queue. When all
+// slots are taken, Acquire waits in the queue for up to maxWait; a released slot
+// is handed directly to the longest waiting request.
+type QueueLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	inUse     int
+	queueSize int
+	maxWait   time.Duration
+	waiters   list.List
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+func NewQueueLimiter(limit, queueSize int, maxWait time.Duration) *QueueLimiter {
+	return &QueueLimiter{
+		limit:     limit,
+		queueSize: queueSize,
+		maxWait:   maxWait,
+	}
+}
+
+// Take takes a slot without waiting. It never jumps the queue.
+func (q *QueueLimiter) Take() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inUse < q.limit && q.waiters.Len() == 0 {
+		q.inUse++
+		return true
+	}
+	return false
+}
+
+type queuedHookKey struct{}
+
+// WithQueuedHook returns a context that makes Acquire call hook when the
+// caller has to wait in the queue, e.g. to tell queued requests in access logs.
+func WithQueuedHook(ctx context.Context, hook func()) context.Context {
+	return context.WithValue(ctx, queuedHookKey{}, hook)
+}
+
+func notifyQueued(ctx context.Context) {
+	if hook, ok := ctx.Value(queuedHookKey{}).(func()); ok {
+		hook()
+	}
+}
+
+// Acquire takes a slot, waiting in the queue when none is free. It fails with
+// ErrQueueFull when the queue is full, with ErrWaitTimeout when the max wait has
+// passed and with the ctx error when the caller has gone away.
+func (q *QueueLimiter) Acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	if q.inUse < q.limit && q.waiters.Len() == 0 {
+		q.inUse++
+		q.mu.Unlock()
+		return nil
+	}
+	if q.waiters.Len() >= q.queueSize {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := q.waiters.PushBack(w)
+	q.mu.Unlock()
+	notifyQueued(ctx)
+
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = ErrWaitTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-w.ready:
+		// the slot was handed over while giving up, pass it on
+		q.releaseLocked()
+	default:
+		q.waiters.Remove(elem)
+	}
+	return err
+}
+
+func (q *QueueLimiter) Release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+func (q *QueueLimiter) releaseLocked() {
+	q.inUse--
+	q.grantLocked()
+}
+
+// grantLocked hands the free slots to the longest waiting requests.
+func (q *QueueLimiter) grantLocked() {
+	for q.inUse < q.limit {
+		front := q.waiters.Front()
+		if front == nil {
+			return
+		}
+		q.waiters.Remove(front)
+		q.inUse++
+		if w, ok := front.Value.(*waiter); ok {
+			close(w.ready)
+		}
+	}
+}
+
+func (q *QueueLimiter) SetLimit(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limit = n
+	q.grantLocked()
+}
+
+func (q *QueueLimiter) State() State {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return State{
+		Limit:     q.limit,
+		Remaining: max(q.limit-q.inUse, 0),
+	}
+}
@@ -0,0 +1,295 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// minRateWait keeps Acquire from spinning when a limiter estimates a zero wait.
+const minRateWait = time.Millisecond
+
+// Limiter is implemented by every limiter of the package. Concurrency limiters
+// hold a slot until Release; for rate limiters Release is a no-op, so both kinds
+// can be chained in front of a handler.
+type Limiter interface {
+	Take() bool
+	Release()
+}
+
+// reserve takes a permit or tells how long to wait before trying again.
+type reserveFunc func(now time.Time) (time.Duration, bool)
+
+// acquireRate waits until reserve grants a permit or ctx is done.
+func acquireRate(ctx context.Context, reserve reserveFunc) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		wait, ok := reserve(time.Now())
+		if ok {
+			return nil
+		}
+
+		timer := time.NewTimer(max(wait, minRateWait))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// TokenBucket allows limit requests per period on average with bursts of up to burst requests.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per nanosecond
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(limit int, period time.Duration, burst int) *TokenBucket {
+	burst = max(burst, 1)
+	return &TokenBucket{
+		rate:   float64(limit) / float64(period),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) Take() bool {
+	_, ok := b.reserve(time.Now())
+	return ok
+}
+
+// Release is a no-op, a spent token is never returned.
+func (b *TokenBucket) Release() {}
+
+// Acquire waits for a token until ctx is done.
+func (b *TokenBucket) Acquire(ctx context.Context) error {
+	return acquireRate(ctx, b.reserve)
+}
+
+func (b *TokenBucket) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	return State{
+		Limit:      int(b.burst),
+		Remaining:  int(b.tokens),
+		Reset:      time.Duration((b.burst - b.tokens) / b.rate),
+		RetryAfter: b.wait(),
+	}
+}
+
+func (b *TokenBucket) reserve(now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return b.wait(), false
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+float64(elapsed)*b.rate)
+		b.last = now
+	}
+}
+
+// wait returns the time until the next token.
+func (b *TokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate)
+}
+
+// SlidingWindowLog allows at most limit requests in any window. It keeps the
+// time of every admitted request, so it is exact but uses memory proportional to limit.
+type SlidingWindowLog struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	log    []time.Time
+}
+
+func NewSlidingWindowLog(limit int, window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+	}
+}
+
+func (l *SlidingWindowLog) Take() bool {
+	_, ok := l.reserve(time.Now())
+	return ok
+}
+
+// Release is a no-op, an admitted request stays in the log for the whole window.
+func (l *SlidingWindowLog) Release() {}
+
+// Acquire waits until the window has room until ctx is done.
+func (l *SlidingWindowLog) Acquire(ctx context.Context) error {
+	return acquireRate(ctx, l.reserve)
+}
+
+func (l *SlidingWindowLog) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.expire(now)
+	st := State{
+		Limit:     l.limit,
+		Remaining: max(l.limit-len(l.log), 0),
+	}
+	if len(l.log) > 0 {
+		st.Reset = l.log[len(l.log)-1].Add(l.window).Sub(now)
+	}
+	if st.Remaining == 0 {
+		st.RetryAfter = l.wait(now)
+	}
+	return st
+}
+
+func (l *SlidingWindowLog) reserve(now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire(now)
+	if len(l.log) < l.limit {
+		l.log = append(l.log, now)
+		return 0, true
+	}
+	return l.wait(now), false
+}
+
+// expire drops the requests that have left the window.
+func (l *SlidingWindowLog) expire(now time.Time) {
+	cutoff := now.Add(-l.window)
+	expired := 0
+	for expired < len(l.log) && !l.log[expired].After(cutoff) {
+		expired++
+	}
+	l.log = append(l.log[:0], l.log[expired:]...)
+}
+
+// wait returns the time until the oldest request leaves the window.
+func (l *SlidingWindowLog) wait(now time.Time) time.Duration {
+	if len(l.log) == 0 {
+		return l.window
+	}
+	return l.log[0].Add(l.window).Sub(now)
+}
+
+// SlidingWindowCounter approximates a sliding window with counters of the current
+// and the previous fixed windows, weighting the previous one by its overlap with
+// the sliding window. It uses constant memory.
+type SlidingWindowCounter struct {
+	mu          sync.Mutex
+	limit       int
+	window      time.Duration
+	windowStart time.Time
+	prev        int
+	curr        int
+}
+
+func NewSlidingWindowCounter(limit int, window time.Duration) *SlidingWindowCounter {
+	return &SlidingWindowCounter{
+		limit:       limit,
+		window:      window,
+		windowStart: time.Now(),
+	}
+}
+
+func (c *SlidingWindowCounter) Take() bool {
+	_, ok := c.reserve(time.Now())
+	return ok
+}
+
+// Release is a no-op, an admitted request is counted for the whole window.
+func (c *SlidingWindowCounter) Release() {}
+
+// Acquire waits until the window has room until ctx is done.
+func (c *SlidingWindowCounter) Acquire(ctx context.Context) error {
+	return acquireRate(ctx, c.reserve)
+}
+
+func (c *SlidingWindowCounter) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.advance(now)
+	elapsed := now.Sub(c.windowStart)
+	st := State{
+		Limit:     c.limit,
+		Remaining: max(c.limit-int(math.Ceil(c.estimate(elapsed))), 0),
+	}
+	// the current window is fully counted until it has slid out after the next one
+	switch {
+	case c.curr > 0:
+		st.Reset = 2*c.window - elapsed
+	case c.prev > 0:
+		st.Reset = c.window - elapsed
+	}
+	if st.Remaining == 0 {
+		st.RetryAfter = c.wait(elapsed)
+	}
+	return st
+}
+
+func (c *SlidingWindowCounter) reserve(now time.Time) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.advance(now)
+	elapsed := now.Sub(c.windowStart)
+	if c.estimate(elapsed) < float64(c.limit) {
+		c.curr++
+		return 0, true
+	}
+	return c.wait(elapsed), false
+}
+
+// estimate returns the number of requests in the sliding window ending elapsed after the window start.
+func (c *SlidingWindowCounter) estimate(elapsed time.Duration) float64 {
+	prevWeight := 1 - float64(elapsed)/float64(c.window)
+	return float64(c.prev)*prevWeight + float64(c.curr)
+}
+
+// wait returns the time until the estimate drops below the limit.
+func (c *SlidingWindowCounter) wait(elapsed time.Duration) time.Duration {
+	// the estimate only decreases as the previous window slides out
+	if c.curr >= c.limit || c.prev == 0 {
+		return c.window - elapsed
+	}
+	need := 1 - float64(c.limit-c.curr)/float64(c.prev)
+	return time.Duration(need*float64(c.window)) - elapsed
+}
+
+// advance moves the fixed windows forward to the one containing now.
+func (c *SlidingWindowCounter) advance(now time.Time) {
+	passed := int(now.Sub(c.windowStart) / c.window)
+	switch {
+	case passed <= 0:
+		return
+	case passed == 1:
+		c.prev = c.curr
+	default:
+		c.prev = 0
+	}
+	c.curr = 0
+	c.windowStart = c.windowStart.Add(time.Duration(passed) * c.window)
+}
@@ -0,0 +1,103 @@
+package limiter
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	metricGoroutines = "/sched/goroutines:goroutines"
+	metricHeapBytes  = "/memory/classes/heap/objects:bytes"
+)
+
+// ShedConfig configures LoadShedder. A zero threshold is not checked.
+type ShedConfig struct {
+	// MaxGoroutines is the max number of live goroutines.
+	MaxGoroutines int
+	// MaxHeapBytes is the max size of the heap objects, live and not yet swept.
+	MaxHeapBytes uint64
+	// MaxQueueLatency is the max time a request waited for a limiter slot.
+	MaxQueueLatency time.Duration
+	// Interval is how often the signals are sampled. Queue latency is the
+	// longest wait observed during the last interval.
+	Interval time.Duration
+}
+
+// Enabled reports whether any threshold is set.
+func (c ShedConfig) Enabled() bool {
+	return c.MaxGoroutines > 0 || c.MaxHeapBytes > 0 || c.MaxQueueLatency > 0
+}
+
+// LoadShedder admits requests by the health of the process rather than by a
+// slot count: when upstreams slow down, the slot limiters keep letting work in
+// while goroutines, memory and queue waits pile up. It samples the runtime
+// metrics at most once per interval on the request path, so it needs no
+// background goroutine, and recovers as soon as the signals are back under
+// their thresholds.
+type LoadShedder struct {
+	cfg ShedConfig
+
+	mu        sync.Mutex
+	samples   []metrics.Sample
+	sampledAt time.Time
+	// maxWait is the longest queue wait since the last sample.
+	maxWait time.Duration
+	err     error
+}
+
+func NewLoadShedder(cfg ShedConfig) *LoadShedder {
+	return &LoadShedder{
+		cfg: cfg,
+		samples: []metrics.Sample{
+			{Name: metricGoroutines},
+			{Name: metricHeapBytes},
+		},
+	}
+}
+
+// Check returns an error wrapping ErrOverloaded when a new request should be shed.
+func (s *LoadShedder) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := time.Now(); now.Sub(s.sampledAt) >= s.cfg.Interval {
+		s.sample(now)
+	}
+	return s.err
+}
+
+// ObserveQueueLatency records the time a request has waited for a limiter slot.
+func (s *LoadShedder) ObserveQueueLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxWait = max(s.maxWait, d)
+}
+
+func (s *LoadShedder) sample(now time.Time) {
+	metrics.Read(s.samples)
+	goroutines := sampleUint64(s.samples[0])
+	heap := sampleUint64(s.samples[1])
+	wait := s.maxWait
+	s.maxWait = 0
+	s.sampledAt = now
+
+	switch {
+	case s.cfg.MaxGoroutines > 0 && goroutines > uint64(s.cfg.MaxGoroutines):
+		s.err = fmt.Errorf("%w: %d goroutines", ErrOverloaded, goroutines)
+	case s.cfg.MaxHeapBytes > 0 && heap > s.cfg.MaxHeapBytes:
+		s.err = fmt.Errorf("%w: %d heap bytes", ErrOverloaded, heap)
+	case s.cfg.MaxQueueLatency > 0 && wait > s.cfg.MaxQueueLatency:
+		s.err = fmt.Errorf("%w: %s queue latency", ErrOverloaded, wait)
+	default:
+		s.err = nil
+	}
+}
+
+func sampleUint64(s metrics.Sample) uint64 {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.Value.Uint64()
+}
@@ -0,0 +1,38 @@
+package limiter
+
+import "time"
+
+// State is a snapshot of a limiter, as advertised to clients in the RateLimit
+// headers. For concurrency limiters Limit and Remaining are slots; they know
+// nothing about when a slot is freed, so their Reset and RetryAfter are zero,
+// which means unknown. The zero State is no state at all, e.g. of a Chain
+// without Staters, and isn't advertised.
+type State struct {
+	Limit     int
+	Remaining int
+	// Reset is the time until the limiter has all of its capacity back.
+	Reset time.Duration
+	// RetryAfter is the time until the next request can be admitted.
+	RetryAfter time.Duration
+}
+
+// Stater is implemented by every limiter of the package.
+type Stater interface {
+	State() State
+}
+
+// Resizable is implemented by the concurrency limiters whose capacity can be
+// changed at runtime.
+type Resizable interface {
+	// SetLimit changes the capacity to n. Slots taken above a lowered limit are
+	// kept until released, a raised limit admits waiters at once.
+	SetLimit(n int)
+}
+
+// mostRestrictive returns the state that admits fewer requests.
+func mostRestrictive(a, b State) State {
+	if b.Remaining < a.Remaining || (b.Remaining == a.Remaining && b.RetryAfter > a.RetryAfter) {
+		return b
+	}
+	return a
+}
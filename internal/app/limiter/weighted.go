@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Weighted is a semaphore where a request takes as many units as it costs.
+// Waiters are served in FIFO order, so a heavy request is not starved by light ones.
+type Weighted struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List
+}
+
+type weightedWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func NewWeighted(size int64) *Weighted {
+	return &Weighted{size: size}
+}
+
+// TryAcquire takes n units without waiting.
+func (w *Weighted) TryAcquire(n int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size-w.cur >= n && w.waiters.Len() == 0 {
+		w.cur += n
+		return true
+	}
+	return false
+}
+
+// Acquire waits for n units until ctx is done. A request larger than the
+// semaphore fails with ErrLimitExceeded at once.
+func (w *Weighted) Acquire(ctx context.Context, n int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if n > w.size {
+		w.mu.Unlock()
+		return ErrLimitExceeded
+	}
+	if w.size-w.cur >= n && w.waiters.Len() == 0 {
+		w.cur += n
+		w.mu.Unlock()
+		return nil
+	}
+	waiter := &weightedWaiter{n: n, ready: make(chan struct{})}
+	elem := w.waiters.PushBack(waiter)
+	w.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return nil
+	case <-ctx.Done():
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		select {
+		case <-waiter.ready:
+			// acquired while giving up, give the units back
+			w.cur -= n
+			w.notifyWaiters()
+		default:
+			isFront := w.waiters.Front() == elem
+			w.waiters.Remove(elem)
+			// the units held back for this waiter may now fit the next ones
+			if isFront {
+				w.notifyWaiters()
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+func (w *Weighted) Release(n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cur -= n
+	w.notifyWaiters()
+}
+
+// Available returns the number of free units.
+func (w *Weighted) Available() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size - w.cur
+}
+
+func (w *Weighted) State() State {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return State{
+		Limit:     int(w.size),
+		Remaining: int(max(w.size-w.cur, 0)),
+	}
+}
+
+func (w *Weighted) notifyWaiters() {
+	for {
+		front := w.waiters.Front()
+		if front == nil {
+			return
+		}
+		waiter, _ := front.Value.(*weightedWaiter)
+		if w.size-w.cur < waiter.n {
+			return
+		}
+		w.cur += waiter.n
+		w.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
@@ -0,0 +1,413 @@
+// Package metrics is a minimal Prometheus client: counters, gauges and
+// histograms with labels, exposed in the Prometheus text format.
+//
+// All metric types are safe to use through nil pointers, which is a no-op, so
+// that components can be instrumented unconditionally and run without a registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry keeps metric families and writes them out.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+	}
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.names[f.name]; ok {
+		panic(fmt.Sprintf("metrics: %s is already registered", f.name))
+	}
+	r.names[f.name] = struct{}{}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(newFamily(name, help, typeCounter, labels, nil))}
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(newFamily(name, help, typeGauge, labels, nil))}
+}
+
+// NewHistogramVec registers a histogram; nil buckets mean DefBuckets.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{f: r.register(newFamily(name, help, typeHistogram, labels, buckets))}
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w) //nolint:errcheck // the scraper has gone away
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// family is a metric with all of its label combinations.
+type family struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64
+
+	mu     sync.RWMutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	// value holds float64 bits of a counter or a gauge.
+	value atomic.Uint64
+	fn    func() float64
+	hist  *histogram
+}
+
+func newFamily(name, help, typ string, labels []string, buckets []float64) *family {
+	return &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok = f.series[key]; ok {
+		return s
+	}
+	s = &series{values: append([]string(nil), values...)}
+	if f.typ == typeHistogram {
+		s.hist = &histogram{counts: make([]uint64, len(f.buckets))}
+	}
+	f.series[key] = s
+	return s
+}
+
+// setFunc makes the series of the values report fn.
+func (f *family) setFunc(values []string, fn func() float64) {
+	s := f.with(values)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s.fn = fn
+}
+
+func (f *family) write(w *bufio.Writer) {
+	type sample struct {
+		key string
+		s   *series
+		fn  func() float64
+	}
+
+	f.mu.RLock()
+	samples := make([]sample, 0, len(f.series))
+	for k, s := range f.series {
+		samples = append(samples, sample{key: k, s: s, fn: s.fn})
+	}
+	f.mu.RUnlock()
+	if len(samples) == 0 {
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].key < samples[j].key })
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, smp := range samples {
+		if smp.s.hist != nil {
+			smp.s.hist.write(w, f, smp.s.values)
+			continue
+		}
+		v := math.Float64frombits(smp.s.value.Load())
+		if smp.fn != nil {
+			v = smp.fn()
+		}
+		writeSample(w, f.name, f.labels, smp.s.values, "", "", v)
+	}
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// writeSample writes a single line; extraLabel is the le label of histogram buckets.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	s *series
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if c == nil || v < 0 {
+		return
+	}
+	c.s.add(v)
+}
+
+type CounterVec struct {
+	f *family
+}
+
+// With returns the counter of the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	if v == nil {
+		return nil
+	}
+	return &Counter{s: v.f.with(values)}
+}
+
+// Func makes the counter of the label values report fn, e.g. a counter kept by another component.
+func (v *CounterVec) Func(fn func() float64, values ...string) {
+	if v == nil {
+		return
+	}
+	v.f.setFunc(values, fn)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	s *series
+}
+
+func (g *Gauge) Set(v float64) {
+	if g == nil {
+		return
+	}
+	g.s.value.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	if g == nil {
+		return
+	}
+	g.s.add(v)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+type GaugeVec struct {
+	f *family
+}
+
+// With returns the gauge of the label values, in the order of the labels.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	if v == nil {
+		return nil
+	}
+	return &Gauge{s: v.f.with(values)}
+}
+
+// Func makes the gauge of the label values report fn at every scrape.
+func (v *GaugeVec) Func(fn func() float64, values ...string) {
+	if v == nil {
+		return
+	}
+	v.f.setFunc(values, fn)
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i := sort.SearchFloat64s(buckets, v); i < len(buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w *bufio.Writer, f *family, values []string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, b := range f.buckets {
+		cumulative += counts[i]
+		writeSample(w, f.name+"_bucket", f.labels, values, "le", formatFloat(b), float64(cumulative))
+	}
+	writeSample(w, f.name+"_bucket", f.labels, values, "le", "+Inf", float64(count))
+	writeSample(w, f.name+"_sum", f.labels, values, "", "", sum)
+	writeSample(w, f.name+"_count", f.labels, values, "", "", float64(count))
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	f *family
+	s *series
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.s.hist.observe(h.f.buckets, v)
+}
+
+type HistogramVec struct {
+	f *family
+}
+
+// With returns the histogram of the label values, in the order of the labels.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	if v == nil {
+		return nil
+	}
+	return &Histogram{f: v.f, s: v.f.with(values)}
+}
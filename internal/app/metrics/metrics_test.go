@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	requests := reg.NewCounterVec("requests_total", "Requests by code.", "code")
+	requests.With("200").Inc()
+	requests.With("200").Add(2)
+	requests.With("429").Inc()
+
+	inflight := reg.NewGauge("inflight", "In flight.")
+	inflight.Inc()
+	inflight.Inc()
+	inflight.Dec()
+
+	slots := reg.NewGaugeVec("slots", "Slots.", "limiter")
+	slots.Func(func() float64 { return 7 }, `a"b`)
+
+	latency := reg.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "host")
+	latency.With("a.com").Observe(0.05)
+	latency.With("a.com").Observe(0.5)
+	latency.With("a.com").Observe(5)
+
+	reg.NewCounterVec("unused_total", "Not used.", "x")
+
+	var buf bytes.Buffer
+	_, err := reg.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `# HELP inflight In flight.
+# TYPE inflight gauge
+inflight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{host="a.com",le="0.1"} 1
+latency_seconds_bucket{host="a.com",le="1"} 2
+latency_seconds_bucket{host="a.com",le="+Inf"} 3
+latency_seconds_sum{host="a.com"} 5.55
+latency_seconds_count{host="a.com"} 3
+# HELP requests_total Requests by code.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="429"} 1
+# HELP slots Slots.
+# TYPE slots gauge
+slots{limiter="a\"b"} 7
+`, buf.String())
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+	require.Equal(t, buf.String(), w.Body.String())
+
+	require.Panics(t, func() { reg.NewGauge("inflight", "Again.") })
+}
+
+func TestNil(t *testing.T) {
+	var counters *metrics.CounterVec
+	var gauges *metrics.GaugeVec
+	var histograms *metrics.HistogramVec
+
+	require.NotPanics(t, func() {
+		counters.With("a").Inc()
+		gauges.With("a").Set(1)
+		gauges.Func(func() float64 { return 1 }, "a")
+		histograms.With("a").Observe(1)
+	})
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+// AccessLogFormat is the line format of the access log.
+type AccessLogFormat string
+
+const (
+	// AccessLogCommon is the Common Log Format followed by the duration, the
+	// number of URLs and the limiter outcome.
+	AccessLogCommon AccessLogFormat = "common"
+	// AccessLogCombined is AccessLogCommon with the referer and the user agent.
+	AccessLogCombined AccessLogFormat = "combined"
+	// AccessLogJSON writes a JSON object per line.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+func ParseAccessLogFormat(s string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(s); f {
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown access log format %q", s)
+	}
+}
+
+type accessLogLine struct {
+	Time      string  `json:"time"`
+	RemoteIP  string  `json:"remote_ip"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	URLs      int     `json:"urls"`
+	Limiter   string  `json:"limiter"`
+	RequestID string  `json:"request_id,omitempty"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// AccessLogMiddleware writes a line per request to out. clientIP tells the
+// address of the client, e.g. behind trusted proxies; nil means the remote
+// address of the connection. Handlers and limiters add the number of URLs and
+// the limiter outcome through accesslog.FromContext.
+func AccessLogMiddleware(
+	out io.Writer, format AccessLogFormat, clientIP func(r *http.Request) string, next http.Handler,
+) http.Handler {
+	if clientIP == nil {
+		clientIP = remoteIP
+	}
+	var mu sync.Mutex
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accesslog.Entry{}
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(accesslog.NewContext(r.Context(), entry)))
+
+		line := accessLogLine{
+			Time:      start.Format(time.RFC3339Nano),
+			RemoteIP:  clientIP(r),
+			Method:    r.Method,
+			URI:       r.RequestURI,
+			Proto:     r.Proto,
+			Status:    rec.Status(),
+			Bytes:     rec.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			URLs:      entry.URLs(),
+			Limiter:   entry.Limiter().String(),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		line.RequestID, _ = requestid.FromContext(r.Context())
+
+		var b []byte
+		if format == AccessLogJSON {
+			b, _ = json.Marshal(line) // plain strings and numbers can't fail
+			b = append(b, '\n')
+		} else {
+			b = appendCLF(nil, line, start, format == AccessLogCombined)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(b) //nolint:errcheck // nowhere to report it
+	})
+}
+
+// appendCLF formats a line as
+//
+//	host - - [time] "request" status bytes ["referer" "user agent"] duration urls limiter
+func appendCLF(b []byte, l accessLogLine, start time.Time, combined bool) []byte {
+	b = append(b, l.RemoteIP...)
+	b = append(b, " - - ["...)
+	b = start.AppendFormat(b, clfTimeLayout)
+	b = append(b, "] \""...)
+	b = appendEscaped(b, l.Method+" "+l.URI+" "+l.Proto)
+	b = append(b, "\" "...)
+	b = strconv.AppendInt(b, int64(l.Status), 10)
+	b = append(b, ' ')
+	if l.Bytes > 0 {
+		b = strconv.AppendInt(b, int64(l.Bytes), 10)
+	} else {
+		b = append(b, '-')
+	}
+	if combined {
+		b = append(b, " \""...)
+		b = appendEscaped(b, orDash(l.Referer))
+		b = append(b, "\" \""...)
+		b = appendEscaped(b, orDash(l.UserAgent))
+		b = append(b, '"')
+	}
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, l.Duration, 'f', 3, 64)
+	b = append(b, "ms "...)
+	b = strconv.AppendInt(b, int64(l.URLs), 10)
+	b = append(b, ' ')
+	b = append(b, l.Limiter...)
+	return append(b, '\n')
+}
+
+// appendEscaped appends s with quotes, backslashes and non-printable bytes
+// escaped, so that client supplied values can't forge log lines.
+func appendEscaped(b []byte, s string) []byte {
+	q := strconv.AppendQuoteToASCII(nil, s)
+	return append(b, q[1:len(q)-1]...)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setOutcome records what a limiter did to the request for the access log.
+func setOutcome(r *http.Request, o accesslog.Outcome) {
+	accesslog.FromContext(r.Context()).SetLimiter(o)
+}
+
+// queuedContext is the context to acquire a queue slot with, it records the
+// request as queued when it has to wait.
+func queuedContext(r *http.Request) context.Context {
+	entry := accesslog.FromContext(r.Context())
+	if entry == nil {
+		return r.Context()
+	}
+	return limiter.WithQueuedHook(r.Context(), func() {
+		entry.SetLimiter(accesslog.OutcomeQueued)
+	})
+}
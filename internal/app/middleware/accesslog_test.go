@@ -0,0 +1,102 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accesslog.FromContext(r.Context()).SetURLs(3)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello")) //nolint:errcheck
+	})
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/crawl?debug=1", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("Referer", "http://example.com/")
+		r.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+		return r
+	}
+
+	t.Run("common", func(t *testing.T) {
+		var out bytes.Buffer
+		h := middleware.AccessLogMiddleware(&out, middleware.AccessLogCommon, nil,
+			middleware.LimitMiddleware(limiter.NewAtomLimiter(1), handler))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest())
+
+		require.Regexp(t, regexp.MustCompile(
+			`^192\.0\.2\.1 - - \[[^\]]+\] "POST /crawl\?debug=1 HTTP/1\.1" 202 5 [0-9.]+ms 3 admitted\n$`,
+		), out.String())
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		var out bytes.Buffer
+		h := middleware.AccessLogMiddleware(&out, middleware.AccessLogCombined, nil, handler)
+		h.ServeHTTP(httptest.NewRecorder(), newRequest())
+
+		require.Contains(t, out.String(), `202 5 "http://example.com/" "curl/8.0 \"quoted\"" `)
+		require.True(t, strings.HasSuffix(out.String(), " 3 -\n"), out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		clientIP := func(*http.Request) string { return "198.51.100.7" }
+		h := middleware.AccessLogMiddleware(&out, middleware.AccessLogJSON, clientIP,
+			middleware.LimitMiddleware(limiter.NewAtomLimiter(0), handler))
+		h.ServeHTTP(httptest.NewRecorder(), newRequest())
+
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+		require.Equal(t, "198.51.100.7", line["remote_ip"])
+		require.Equal(t, "POST", line["method"])
+		require.Equal(t, "/crawl?debug=1", line["uri"])
+		require.EqualValues(t, http.StatusTooManyRequests, line["status"])
+		require.EqualValues(t, 0, line["urls"])
+		require.Equal(t, "rejected", line["limiter"])
+	})
+}
+
+func TestAccessLogMiddleware_Queued(t *testing.T) {
+	l := limiter.NewQueueLimiter(1, 1, time.Second)
+	require.NoError(t, l.Acquire(context.Background()))
+	time.AfterFunc(50*time.Millisecond, l.Release)
+
+	var out bytes.Buffer
+	h := middleware.AccessLogMiddleware(&out, middleware.AccessLogJSON, nil,
+		middleware.QueueLimitMiddleware(l, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &line))
+	require.EqualValues(t, http.StatusOK, line["status"])
+	require.Equal(t, "queued", line["limiter"])
+}
+
+func TestAccessLogMiddleware_Flush(t *testing.T) {
+	var out bytes.Buffer
+	h := middleware.AccessLogMiddleware(&out, middleware.AccessLogCommon, nil,
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, ok := w.(http.Flusher)
+			require.True(t, ok)
+			require.NoError(t, http.NewResponseController(w).Flush())
+		}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, rec.Flushed)
+	require.Contains(t, out.String(), `"GET / HTTP/1.1" 200 - `)
+}
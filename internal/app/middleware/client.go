@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+type identifier interface {
+	Identify(r *http.Request) clientid.Identity
+}
+
+type keyedLimiter interface {
+	Get(key string, create func() limiter.Limiter) limiter.Limiter
+}
+
+// ClientLimitMiddleware limits every client separately. The client identity is
+// stored in the request context, and newLimiter creates limiters for the client's tier.
+func ClientLimitMiddleware(
+	id identifier, limiters keyedLimiter, newLimiter func(tier string) limiter.Limiter, next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := id.Identify(r)
+		l := limiters.Get(client.Key, func() limiter.Limiter {
+			return newLimiter(client.Tier)
+		})
+
+		if !l.Take() {
+			setRateLimit(w, l, true)
+			setOutcome(r, accesslog.OutcomeRejected)
+			httpresp.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		defer l.Release()
+		setRateLimit(w, l, false)
+		setOutcome(r, accesslog.OutcomeAdmitted)
+		next.ServeHTTP(w, r.WithContext(clientid.NewContext(r.Context(), client)))
+	})
+}
@@ -3,21 +3,26 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
 	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
 )
 
-type limiter interface {
+type taker interface {
 	Take() bool
 	Release()
 }
 
-func LimitMiddleware(l limiter, next http.Handler) http.Handler {
+func LimitMiddleware(l taker, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !l.Take() {
+			setRateLimit(w, l, true)
+			setOutcome(r, accesslog.OutcomeRejected)
 			httpresp.Error(w, "Too many requests", http.StatusTooManyRequests)
 			return
 		}
 		defer l.Release()
+		setRateLimit(w, l, false)
+		setOutcome(r, accesslog.OutcomeAdmitted)
 		next.ServeHTTP(w, r)
 	})
 }
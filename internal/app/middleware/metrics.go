@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/apoldev/go-http/internal/app/metrics"
+)
+
+// MetricsMiddleware counts the requests to mux by route, the pattern the
+// request has matched, and status code. Unlike the path, the route can't take
+// values the routes don't have.
+func MetricsMiddleware(requests *metrics.CounterVec, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		rec := newResponseRecorder(w)
+		mux.ServeHTTP(rec, r)
+		requests.With(route, strconv.Itoa(rec.Status())).Inc()
+	})
+}
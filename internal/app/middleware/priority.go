@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+// HeaderPriority tags a request with its priority: low, normal or high.
+const HeaderPriority = "X-Priority"
+
+type priorityAcquirer interface {
+	Acquire(ctx context.Context, p limiter.Priority) error
+	Release()
+}
+
+// PriorityClassifier tells the priority of a request by the tier of its client,
+// see ClientLimitMiddleware, and by the X-Priority header. Unless the header is
+// trusted, e.g. when set by an authenticating proxy, it may only lower the priority.
+type PriorityClassifier struct {
+	tierPriorities map[string]limiter.Priority
+	trustHeader    bool
+}
+
+func NewPriorityClassifier(tierPriorities map[string]limiter.Priority, trustHeader bool) *PriorityClassifier {
+	return &PriorityClassifier{
+		tierPriorities: tierPriorities,
+		trustHeader:    trustHeader,
+	}
+}
+
+func (c *PriorityClassifier) Classify(r *http.Request) limiter.Priority {
+	p := limiter.PriorityNormal
+	if client, ok := clientid.FromContext(r.Context()); ok {
+		if tp, ok := c.tierPriorities[client.Tier]; ok {
+			p = tp
+		}
+	}
+
+	if v := r.Header.Get(HeaderPriority); v != "" {
+		if hp, err := limiter.ParsePriority(v); err == nil && (c.trustHeader || hp < p) {
+			p = hp
+		}
+	}
+	return p
+}
+
+// PriorityBypassMiddleware passes high priority requests straight to next and
+// the rest through limited. next must bound the high priority requests itself,
+// e.g. with the reserved slots of a priority limiter.
+func PriorityBypassMiddleware(
+	classify func(r *http.Request) limiter.Priority, limited func(next http.Handler) http.Handler, next http.Handler,
+) http.Handler {
+	through := limited(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if classify(r) == limiter.PriorityHigh {
+			next.ServeHTTP(w, r)
+			return
+		}
+		through.ServeHTTP(w, r)
+	})
+}
+
+// PriorityLimitMiddleware is QueueLimitMiddleware for limiters with priority queues.
+func PriorityLimitMiddleware(
+	l priorityAcquirer, classify func(r *http.Request) limiter.Priority, next http.Handler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.Acquire(queuedContext(r), classify(r)); err != nil {
+			setRateLimit(w, l, true)
+			setOutcome(r, accesslog.OutcomeRejected)
+			acquireError(w, err)
+			return
+		}
+		defer l.Release()
+		setRateLimit(w, l, false)
+		setOutcome(r, accesslog.OutcomeAdmitted)
+		next.ServeHTTP(w, r)
+	})
+}
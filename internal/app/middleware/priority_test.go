@@ -0,0 +1,37 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+type overloaded struct{}
+
+func (overloaded) Check() error { return limiter.ErrShed }
+
+func TestPriorityBypassMiddleware(t *testing.T) {
+	classifier := middleware.NewPriorityClassifier(nil, true)
+	shed := func(next http.Handler) http.Handler {
+		return middleware.LoadShedMiddleware(overloaded{}, time.Second, next)
+	}
+	handler := middleware.PriorityBypassMiddleware(classifier.Classify, shed,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for priority, code := range map[string]int{
+		"high":   http.StatusOK,
+		"normal": http.StatusServiceUnavailable,
+		"low":    http.StatusServiceUnavailable,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(middleware.HeaderPriority, priority)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, code, w.Code, priority)
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+type acquirer interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// QueueLimitMiddleware is LimitMiddleware for limiters that can wait for a slot.
+// A request waits until it gets a slot, the limiter gives up on it or the client
+// goes away.
+func QueueLimitMiddleware(l acquirer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.Acquire(queuedContext(r)); err != nil {
+			setRateLimit(w, l, true)
+			setOutcome(r, accesslog.OutcomeRejected)
+			acquireError(w, err)
+			return
+		}
+		defer l.Release()
+		setRateLimit(w, l, false)
+		setOutcome(r, accesslog.OutcomeAdmitted)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireError answers a request that has not got a slot.
+func acquireError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, limiter.ErrWaitTimeout), errors.Is(err, limiter.ErrShed):
+		httpresp.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// nobody is waiting for the answer
+	default:
+		httpresp.Error(w, "Too many requests", http.StatusTooManyRequests)
+	}
+}
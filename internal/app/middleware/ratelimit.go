@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+	"github.com/apoldev/go-http/internal/app/limiter"
+)
+
+// setRateLimit advertises the state of l in the response headers. Rejected
+// requests also get Retry-After.
+func setRateLimit(w http.ResponseWriter, l any, rejected bool) {
+	s, ok := l.(limiter.Stater)
+	if !ok {
+		return
+	}
+	st := s.State()
+	httpresp.SetRateLimit(w, st.Limit, st.Remaining, st.Reset)
+	if rejected {
+		httpresp.SetRetryAfter(w, st.RetryAfter)
+	}
+}
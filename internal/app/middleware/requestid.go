@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/lib/requestid"
+	"github.com/apoldev/go-http/pkg/logger"
+)
+
+// RequestIDMiddleware takes the request ID from X-Request-ID or generates a new
+// one. The ID is sent back in the response, stored in the request context and
+// added to every line logged with that context.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+
+		ctx := requestid.NewContext(r.Context(), id)
+		ctx = logger.WithAttrs(ctx, slog.String(logger.KeyRequestID, id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/lib/accesslog"
+	httpresp "github.com/apoldev/go-http/internal/app/lib/http-resp"
+)
+
+type checker interface {
+	Check() error
+}
+
+// LoadShedMiddleware answers 503 with Retry-After while the process is overloaded.
+func LoadShedMiddleware(c checker, retryAfter time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Check(); err != nil {
+			httpresp.SetRetryAfter(w, retryAfter)
+			setOutcome(r, accesslog.OutcomeRejected)
+			httpresp.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
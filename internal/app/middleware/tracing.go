@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/tracing"
+)
+
+var errRejected = errors.New("rejected by limiter")
+
+// TracingMiddleware traces every request in a server span, continuing the
+// trace of the caller when the request has a valid traceparent header.
+func TracingMiddleware(tracer *tracing.Tracer, next http.Handler) http.Handler {
+	if tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := tracing.ParseTraceparent(r.Header.Get(tracing.HeaderTraceparent)); ok {
+			ctx = tracing.ContextWithRemote(ctx, sc)
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, tracing.KindServer,
+			slog.String("http.method", r.Method),
+			slog.String("http.target", r.URL.RequestURI()),
+		)
+		defer span.End()
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		span.SetAttrs(slog.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+	})
+}
+
+// TraceLimitsMiddleware traces the time spent in the limits middleware, waiting
+// in queues included, in a "limiter.wait" span. The span ends once the request
+// has got through all of the limits or has been rejected.
+func TraceLimitsMiddleware(
+	tracer *tracing.Tracer, limits func(next http.Handler) http.Handler, next http.Handler,
+) http.Handler {
+	if tracer == nil {
+		return limits(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent := tracing.SpanFromContext(r.Context())
+		ctx, span := tracer.Start(r.Context(), "limiter.wait", tracing.KindInternal)
+
+		admitted := false
+		limits(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admitted = true
+			span.End()
+			// the rest of the request is not a part of the wait
+			next.ServeHTTP(w, r.WithContext(tracing.ContextWithSpan(r.Context(), parent)))
+		})).ServeHTTP(w, r.WithContext(ctx))
+
+		if !admitted {
+			span.SetError(errRejected)
+			span.End()
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// StdoutExporter writes spans as JSON lines, for local debugging.
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+func (e *StdoutExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for i := range spans {
+		if err := enc.Encode(otlpSpanOf(&spans[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector with OTLP/HTTP in
+// the JSON encoding, e.g. to http://localhost:4318/v1/traces.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+func NewOTLPExporter(endpoint, serviceName string, client *http.Client) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      client,
+	}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpAttr{otlpAttrOf(slog.String("service.name", e.serviceName))}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/apoldev/go-http"},
+				Spans: make([]otlpSpan, 0, len(spans)),
+			}},
+		}},
+	}
+	scope := &req.ResourceSpans[0].ScopeSpans[0]
+	for i := range spans {
+		scope.Spans = append(scope.Spans, otlpSpanOf(&spans[i]))
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck // drained for connection reuse
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see opentelemetry-proto. Ids are hex, 64-bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              SpanKind    `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr  `json:"attributes,omitempty"`
+	Events            []otlpEvent `json:"events,omitempty"`
+	Status            otlpStatus  `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	Name         string     `json:"name"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+}
+
+// otlpStatus codes: 0 unset, 2 error.
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func otlpSpanOf(d *SpanData) otlpSpan {
+	s := otlpSpan{
+		TraceID:           d.Context.TraceID.String(),
+		SpanID:            d.Context.SpanID.String(),
+		Name:              d.Name,
+		Kind:              d.Kind,
+		StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+		Attributes:        otlpAttrsOf(d.Attrs),
+	}
+	if d.ParentID != (SpanID{}) {
+		s.ParentSpanID = d.ParentID.String()
+	}
+	for _, ev := range d.Events {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(ev.Time.UnixNano(), 10),
+			Name:         ev.Name,
+			Attributes:   otlpAttrsOf(ev.Attrs),
+		})
+	}
+	if d.Err != "" {
+		s.Status = otlpStatus{Code: 2, Message: d.Err}
+	}
+	return s
+}
+
+func otlpAttrsOf(attrs []slog.Attr) []otlpAttr {
+	out := make([]otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		out = append(out, otlpAttrOf(a))
+	}
+	return out
+}
+
+func otlpAttrOf(a slog.Attr) otlpAttr {
+	v := a.Value.Resolve()
+	var ov otlpValue
+	switch v.Kind() {
+	case slog.KindInt64:
+		s := strconv.FormatInt(v.Int64(), 10)
+		ov.IntValue = &s
+	case slog.KindUint64:
+		s := strconv.FormatUint(v.Uint64(), 10)
+		ov.IntValue = &s
+	case slog.KindFloat64:
+		f := v.Float64()
+		ov.DoubleValue = &f
+	case slog.KindBool:
+		b := v.Bool()
+		ov.BoolValue = &b
+	case slog.KindDuration:
+		// durations are exported in milliseconds, as most tracing UIs show them
+		f := float64(v.Duration().Microseconds()) / 1000
+		ov.DoubleValue = &f
+	default:
+		s := v.String()
+		ov.StringValue = &s
+	}
+	return otlpAttr{Key: a.Key, Value: ov}
+}
@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http/httptrace"
+)
+
+// WithClientTrace records the DNS, connect, TLS and first byte phases of the
+// HTTP requests made with the returned context as events of the current span.
+func WithClientTrace(ctx context.Context) context.Context {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			span.AddEvent("get_conn", slog.String("host_port", hostPort))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.AddEvent("got_conn", slog.Bool("reused", info.Reused))
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			span.AddEvent("dns_start", slog.String("host", info.Host))
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			span.AddEvent("dns_done", errAttrs(info.Err)...)
+		},
+		ConnectStart: func(network, addr string) {
+			span.AddEvent("connect_start", slog.String("addr", addr))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			span.AddEvent("connect_done", append(errAttrs(err), slog.String("addr", addr))...)
+		},
+		TLSHandshakeStart: func() {
+			span.AddEvent("tls_start")
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			span.AddEvent("tls_done", errAttrs(err)...)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			span.AddEvent("wrote_request", errAttrs(info.Err)...)
+		},
+		GotFirstResponseByte: func() {
+			span.AddEvent("first_byte")
+		},
+	})
+}
+
+func errAttrs(err error) []slog.Attr {
+	if err == nil {
+		return nil
+	}
+	return []slog.Attr{slog.String("error", err.Error())}
+}
@@ -0,0 +1,328 @@
+// Package tracing is a minimal distributed tracer: spans with attributes and
+// events, W3C traceparent propagation and batched export, see Exporter.
+//
+// A nil *Tracer and a nil *Span are no-ops, so that components can be
+// instrumented unconditionally and run with tracing turned off.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apoldev/go-http/pkg/logger"
+)
+
+// HeaderTraceparent is the W3C Trace Context header.
+const HeaderTraceparent = "traceparent"
+
+const (
+	flagSampled = 0x01
+
+	defaultBatchSize     = 512
+	defaultFlushInterval = 5 * time.Second
+	queueSize            = 4096
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext identifies a span across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags = flagSampled
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a traceparent header value.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+	return sc, sc.IsValid()
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// SpanKind tells the role of a span in a request, as in OTLP.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Event is a point in time within a span, e.g. the connection being established.
+type Event struct {
+	Name  string
+	Time  time.Time
+	Attrs []slog.Attr
+}
+
+// SpanData is a finished span as handed to the exporter.
+type SpanData struct {
+	Name     string
+	Kind     SpanKind
+	Context  SpanContext
+	ParentID SpanID
+	Start    time.Time
+	End      time.Time
+	Attrs    []slog.Attr
+	Events   []Event
+	// Err is the error message of a failed span.
+	Err string
+}
+
+// Span is an operation being traced.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span context to propagate to downstream services.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+func (s *Span) SetAttrs(attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Attrs = append(s.data.Attrs, attrs...)
+	}
+}
+
+func (s *Span) AddEvent(name string, attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now(), Attrs: attrs})
+	}
+}
+
+// SetError marks the span as failed. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan returns a context in which span is the current span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// ContextWithRemote returns a context with the span context received from an
+// upstream service, to be used as the parent of the next span.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Tracer creates spans and exports them in batches in the background.
+type Tracer struct {
+	exporter Exporter
+	logger   *slog.Logger
+
+	// mu guards queue against sends after Shutdown has closed it.
+	mu     sync.RWMutex
+	closed bool
+	queue  chan SpanData
+	done   chan struct{}
+}
+
+// NewTracer starts a tracer exporting spans with the exporter. Shutdown must
+// be called to flush the remaining spans.
+func NewTracer(exporter Exporter, log *slog.Logger) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		logger:   log.With(logger.KeyComponent, "tracing"),
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span as a child of the current span of ctx, or of the remote
+// span context, or as the root of a new trace, and makes it the current span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...slog.Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:  name,
+			Kind:  kind,
+			Start: time.Now(),
+			Attrs: attrs,
+		},
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.data.Context = SpanContext{TraceID: parent.data.Context.TraceID, Sampled: parent.data.Context.Sampled}
+		span.data.ParentID = parent.data.Context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.data.Context = SpanContext{TraceID: remote.TraceID, Sampled: remote.Sampled}
+		span.data.ParentID = remote.SpanID
+	} else {
+		rand.Read(span.data.Context.TraceID[:]) //nolint:errcheck // never fails on supported platforms
+		span.data.Context.Sampled = true
+	}
+	rand.Read(span.data.Context.SpanID[:]) //nolint:errcheck // never fails on supported platforms
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		// the exporter can't keep up, tracing must not slow down requests
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, defaultBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil {
+			t.logger.Warn("export spans", logger.Err(err), slog.Int("spans", len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case data, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= defaultBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the queued spans. Spans ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
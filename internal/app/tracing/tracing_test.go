@@ -0,0 +1,113 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/app/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := tracing.ParseTraceparent(header)
+	require.True(t, ok)
+	require.True(t, sc.Sampled)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, header, sc.Traceparent())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, ok := tracing.ParseTraceparent(invalid)
+		require.False(t, ok, invalid)
+	}
+}
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *memoryExporter) Export(_ context.Context, spans []tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracer(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer(exporter, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	remote, _ := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := tracing.ContextWithRemote(context.Background(), remote)
+	ctx, root := tracer.Start(ctx, "root", tracing.KindServer)
+	_, child := tracer.Start(ctx, "child", tracing.KindClient, slog.String("host", "google.com"))
+	child.AddEvent("first_byte")
+	child.SetError(errors.New("boom"))
+	child.End()
+	root.End()
+	root.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	require.Len(t, exporter.spans, 2)
+
+	c, r := exporter.spans[0], exporter.spans[1]
+	require.Equal(t, remote.TraceID, r.Context.TraceID)
+	require.Equal(t, remote.SpanID, r.ParentID)
+	require.Equal(t, remote.TraceID, c.Context.TraceID)
+	require.Equal(t, r.Context.SpanID, c.ParentID)
+	require.Equal(t, "boom", c.Err)
+	require.Len(t, c.Events, 1)
+
+	// ending a span after shutdown must not panic
+	_, late := tracer.Start(context.Background(), "late", tracing.KindInternal)
+	late.End()
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *tracing.Tracer
+	ctx, span := tracer.Start(context.Background(), "noop", tracing.KindInternal)
+	require.Nil(t, span)
+	require.Nil(t, tracing.SpanFromContext(ctx))
+	span.SetError(errors.New("boom"))
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer srv.Close()
+
+	tracer := tracing.NewTracer(tracing.NewOTLPExporter(srv.URL, "go-http", srv.Client()),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	_, span := tracer.Start(context.Background(), "crawl.batch", tracing.KindInternal, slog.Int("urls", 2))
+	span.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	rs := body["resourceSpans"].([]any)[0].(map[string]any)
+	attr := rs["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	require.Equal(t, "service.name", attr["key"])
+	s := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	require.Equal(t, "crawl.batch", s["name"])
+	require.Len(t, s["traceId"], 32)
+	require.Equal(t, map[string]any{"intValue": "2"}, s["attributes"].([]any)[0].(map[string]any)["value"])
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/apoldev/go-http/internal/app/handlers"
+	"github.com/apoldev/go-http/internal/app/metrics"
+)
+
+// newAdminServer creates the admin listener with the operational endpoints:
+// metrics, limiter and breaker state, the configuration, pprof, expvar and the
+// crawls in flight. None of them is served on the public address: they expose
+// the internals and the URLs of other clients, and cancel their crawls.
+func newAdminServer(addr string, h *handlers.AdminHandler, registry *metrics.Registry, log *slog.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/admin/breakers", h.Breakers)
+	mux.HandleFunc("/admin/outbound", h.Outbound)
+	mux.HandleFunc("/admin/limiters", h.Limiters)
+	mux.HandleFunc("/admin/config", h.Config)
+	mux.HandleFunc("/admin/buildinfo", h.BuildInfo)
+	mux.HandleFunc(handlers.CrawlsPath, h.Crawls)
+	mux.HandleFunc(handlers.CrawlsPath+"/", h.Crawls)
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	return &http.Server{
+		Addr:        addr,
+		Handler:     mux,
+		IdleTimeout: DefaultServerIdleTimeout,
+		ReadTimeout: DefaultServerReadWriteTimeout,
+		ErrorLog:    slog.NewLogLogger(log.Handler(), slog.LevelWarn),
+	}
+}
@@ -3,7 +3,9 @@ package app
 import (
 	"context"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,90 +14,344 @@ import (
 
 	"github.com/apoldev/go-http/internal/app/crawler"
 	"github.com/apoldev/go-http/internal/app/handlers"
-	"github.com/apoldev/go-http/internal/app/lib/env"
+	"github.com/apoldev/go-http/internal/app/inflight"
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
 	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/metrics"
 	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/apoldev/go-http/internal/app/tracing"
+	"github.com/apoldev/go-http/internal/pkg/config"
 	"github.com/apoldev/go-http/pkg/logger"
 )
 
 type App struct {
-	srv    *http.Server
-	logger logger.Logger
+	cfg          *config.Config
+	reload       func() (*config.Config, error)
+	capacity     limiter.Resizable
+	crawler      *crawler.Service
+	crawlHandler *handlers.HTTPHandler
+	adminHandler *handlers.AdminHandler
+	srv          *http.Server
+	ln           net.Listener
+	admin        *http.Server // nil when ADMIN_ADDR is not set
+	adminLn      net.Listener
+	health       *handlers.HealthHandler
+	drainDelay   time.Duration
+	pool         *crawler.Pool
+	tracer       *tracing.Tracer
+	shuttingDown *metrics.Gauge
+	logger       *slog.Logger
 }
 
 const (
-	DefaultMaxConnections          = 1
-	DefaultMaxUrlsCount            = 20
-	DefaultMaxWorkers              = 4
-	DefaultAddr                    = ":8080"
-	DefaultCrawlerRequestTimeoutMs = 1000
-	DefaultServerReadWriteTimeout  = time.Second * 10
-	DefaultServerIdleTimeout       = time.Second * 60
-	DefaultShutdownTimeout         = time.Second * 15
+	DefaultServerReadWriteTimeout = time.Second * 10
+	DefaultServerIdleTimeout      = time.Second * 60
+	DefaultShutdownTimeout        = time.Second * 15
 )
 
-func New() (*App, error) {
-	addr := env.LookupEnvStringDefault("ADDR", DefaultAddr)
-	maxConnections := env.LookupEnvIntDefault("SERVER_MAX_CONNECTIONS", DefaultMaxConnections)
-	maxUrlsCount := env.LookupEnvIntDefault("CRAWLER_MAX_URLS", DefaultMaxUrlsCount)
-	maxWorkersCount := env.LookupEnvIntDefault("CRAWLER_MAX_WORKERS", DefaultMaxWorkers)
-	crawlerRequestTimeoutMs := env.LookupEnvIntDefault("CRAWLER_REQUEST_TIMEOUT_MS", DefaultCrawlerRequestTimeoutMs)
+const (
+	// DefaultShedSampleInterval is how often the load shedder samples the process.
+	DefaultShedSampleInterval = 100 * time.Millisecond
+	// DefaultTracingExportTimeout bounds a span export to the OTLP collector.
+	DefaultTracingExportTimeout = 10 * time.Second
+)
+
+// New creates the application from a validated configuration, see config.Loader.
+// On SIGHUP the configuration is read again with reload, nil turns reloading off.
+func New(cfg *config.Config, reload func() (*config.Config, error)) (*App, error) {
+	log, err := logger.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := metrics.NewRegistry()
+	limMetrics := newLimiterMetrics(registry)
+
+	lc := cfg.Limiter
+	rateLimitBurst := lc.RateLimitBurst
+	if rateLimitBurst == 0 {
+		rateLimitBurst = lc.RateLimitRPM
+	}
+	limitMiddleware, capacity, err := newLimitMiddleware(limitsConfig{
+		mode:                lc.Mode,
+		maxConnections:      lc.MaxConnections,
+		queueSize:           lc.QueueSize,
+		queueTimeout:        lc.QueueTimeout.Std(),
+		reservedHigh:        lc.ReservedHigh,
+		reservedNormal:      lc.ReservedNormal,
+		trustPriorityHeader: lc.TrustHeader,
+		rateLimitRPM:        lc.RateLimitRPM,
+		rateLimitBurst:      rateLimitBurst,
+		rateLimitAlgorithm:  lc.RateLimitAlgorithm,
+		clientLimits:        cfg.ClientLimits,
+		shed: limiter.ShedConfig{
+			MaxGoroutines:   cfg.Shed.MaxGoroutines,
+			MaxHeapBytes:    uint64(cfg.Shed.MaxHeapMB) << 20,
+			MaxQueueLatency: cfg.Shed.MaxQueueLatency.Std(),
+			Interval:        DefaultShedSampleInterval,
+		},
+		shedRetryAfter: cfg.Shed.RetryAfter.Std(),
+		metrics:        limMetrics,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tracer, err := newTracer(cfg.Tracing.Exporter, cfg.Tracing.OTLPEndpoint, cfg.Tracing.ServiceName, log)
+	if err != nil {
+		return nil, err
+	}
 
-	limiter := limiter.NewAtomLimiter(maxConnections)
+	cc := cfg.Crawler
+	breakers := newBreakers(cc.Breaker)
+	crawlerOpts := append([]crawler.Option{
+		crawler.WithMetrics(crawler.NewMetrics(registry)),
+		crawler.WithTracer(tracer),
+	}, crawlerOptions(cc, breakers)...)
+	var pool *crawler.Pool
+	if cc.PoolSize > 0 {
+		pool = crawler.NewPool(cc.PoolSize)
+		crawlerOpts = append(crawlerOpts, crawler.WithPool(pool))
+	}
+
+	// a nil *crawler.Outbound must not get into the admin handler as a non-nil interface
+	var outboundStater handlers.OutboundStater
+	if cc.MaxOutbound > 0 {
+		outbound := newOutbound(cc)
+		crawlerOpts = append(crawlerOpts, crawler.WithOutbound(outbound))
+		outboundStater = outbound
+		limMetrics.slots(limiterOutbound, staterFunc(func() limiter.State {
+			st := outbound.Stats()
+			return limiter.State{Limit: st.Limit, Remaining: st.Limit - st.InUse}
+		}))
+		limMetrics.rejections.Func(func() float64 { return float64(outbound.Stats().Rejected) }, limiterOutbound)
+	}
 
 	// todo add proxy to client Transport
 	httpClient := http.DefaultClient
 
 	crawleService := crawler.New(
-		maxWorkersCount,
-		crawlerRequestTimeoutMs,
+		cc.MaxWorkers,
+		int(cc.RequestTimeout.Std().Milliseconds()),
 		httpClient,
-		log.New(os.Stdout, "[crawler] ", log.LstdFlags),
+		log,
+		crawlerOpts...,
 	)
+	crawls := inflight.NewRegistry()
+	handlerOpts := []handlers.Option{
+		handlers.WithInflight(crawls),
+		handlers.WithTimeoutLimits(cc.MaxBatchTimeout.Std(), cc.MaxURLTimeout.Std()),
+	}
+	if cfg.Server.MaxInflightURLs > 0 {
+		admission := countedAdmission{
+			Weighted: limiter.NewWeighted(int64(cfg.Server.MaxInflightURLs)),
+			rejected: limMetrics.rejections.With(limiterInflightURLs),
+		}
+		limMetrics.slots(limiterInflightURLs, admission)
+		handlerOpts = append(handlerOpts, handlers.WithAdmission(admission))
+	}
 	httpHandler := handlers.NewHTTPHandler(
 		crawleService,
-		maxUrlsCount,
-		log.New(os.Stdout, "[http] ", log.LstdFlags),
+		cc.MaxURLs,
+		log,
+		handlerOpts...,
 	)
 
 	mux := http.NewServeMux()
-	handler := middleware.LimitMiddleware(limiter, http.HandlerFunc(httpHandler.Crawl))
+	handler := middleware.TracingMiddleware(tracer,
+		middleware.TraceLimitsMiddleware(tracer, limitMiddleware, http.HandlerFunc(httpHandler.Crawl)))
 	mux.Handle("/", handler)
+
+	probes := make([]handlers.Probe, 0, len(cfg.Readiness.ProbeURLs))
+	for _, u := range cfg.Readiness.ProbeURLs {
+		probes = append(probes, handlers.HTTPProbe(http.DefaultClient, u))
+	}
+	health := handlers.NewHealthHandler(probes, cfg.Readiness.ProbeTimeout.Std())
+	mux.HandleFunc("/livez", health.Livez)
+	mux.HandleFunc("/readyz", health.Readyz)
+
+	adminHandler := handlers.NewAdminHandler(breakers, outboundStater,
+		handlers.WithLimiters(limMetrics.states),
+		handlers.WithConfig(cfg.Redacted()),
+		handlers.WithCrawls(crawls),
+	)
+	// without an admin address the operational endpoints aren't served at all,
+	// except the metrics, which expose no client data
+	var admin *http.Server
+	if cfg.Server.AdminAddr != "" {
+		admin = newAdminServer(cfg.Server.AdminAddr, adminHandler, registry, log)
+	} else {
+		mux.Handle("/metrics", registry)
+	}
+
+	requests := registry.NewCounterVec("http_requests_total", "Number of requests by route and status code.",
+		"route", "code")
+	root, err := accessLogMiddleware(cfg.Log.AccessFormat, cfg.Log.AccessTrustedProxies,
+		middleware.MetricsMiddleware(requests, mux))
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &http.Server{
-		Addr:        addr,
-		Handler:     mux,
+		Addr:        cfg.Server.Addr,
+		Handler:     middleware.RequestIDMiddleware(root),
 		IdleTimeout: DefaultServerIdleTimeout,
 		ReadTimeout: DefaultServerReadWriteTimeout,
+		ErrorLog:    slog.NewLogLogger(log.Handler(), slog.LevelWarn),
+	}
+
+	// the addresses are bound here, so that a taken port fails the start
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	var adminLn net.Listener
+	if admin != nil {
+		adminLn, err = net.Listen("tcp", admin.Addr)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
 	}
 
 	return &App{
-		logger: log.New(os.Stdout, "[main] ", log.LstdFlags),
-		srv:    srv,
+		cfg:          cfg,
+		reload:       reload,
+		capacity:     capacity,
+		crawler:      crawleService,
+		crawlHandler: httpHandler,
+		adminHandler: adminHandler,
+		logger:       log.With(logger.KeyComponent, "main"),
+		srv:          srv,
+		ln:           ln,
+		admin:        admin,
+		adminLn:      adminLn,
+		health:       health,
+		drainDelay:   cfg.Server.DrainDelay.Std(),
+		pool:         pool,
+		tracer:       tracer,
+		shuttingDown: registry.NewGauge("app_shutting_down",
+			"1 once the server has received a stop signal and is draining requests."),
 	}, nil
 }
 
+// Run serves until SIGINT or SIGTERM and shuts down gracefully. A listener
+// failing stops the application and its error is returned.
 func (a *App) Run() error {
+	errs := make(chan error, 2)
 	go func() {
-		err := a.srv.ListenAndServe()
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			a.logger.Fatalf("Server failed: %v", err)
-		}
+		errs <- fmt.Errorf("server: %w", a.srv.Serve(a.ln))
 	}()
+	a.logger.Info("server started", slog.String("addr", a.ln.Addr().String()))
 
-	a.logger.Printf("Server started on %s", a.srv.Addr)
+	if a.admin != nil {
+		go func() {
+			errs <- fmt.Errorf("admin server: %w", a.admin.Serve(a.adminLn))
+		}()
+		a.logger.Info("admin server started", slog.String("addr", a.adminLn.Addr().String()))
+	}
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-	<-done
+	defer signal.Stop(done)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var serveErr error
+wait:
+	for {
+		select {
+		case <-hup:
+			a.reloadConfig()
+		case <-done:
+			break wait
+		case serveErr = <-errs:
+			break wait
+		}
+	}
+
+	if serveErr != nil {
+		// nothing to drain for, the other listener is stopped right away
+		a.logger.Error("listener failed", logger.Err(serveErr))
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+		defer cancel()
+		return errors.Join(serveErr, a.shutdown(ctx))
+	}
+
+	a.logger.Info("server stopping", slog.Duration("drain_delay", a.drainDelay))
+	a.shuttingDown.Set(1)
 
-	a.logger.Printf("Server stopping")
+	// readiness fails first, and the server keeps serving for the drain delay
+	// until load balancers have noticed and stopped sending new requests; a
+	// second signal cuts the delay short
+	a.health.ShutDown()
+	a.srv.SetKeepAlivesEnabled(false)
+	select {
+	case <-time.After(a.drainDelay):
+	case <-done:
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
 	defer cancel()
 
+	if err := a.shutdown(ctx); err != nil {
+		return err
+	}
+	a.logger.Info("server stopped")
+	return nil
+}
+
+// shutdown stops the listeners, waiting for the requests in flight, then the
+// crawler pool and the tracer.
+func (a *App) shutdown(ctx context.Context) error {
 	if err := a.srv.Shutdown(ctx); err != nil {
 		return err
 	}
-	a.logger.Printf("Server stopped")
+	// the admin listener stays up while draining, to debug a stuck shutdown
+	if a.admin != nil {
+		if err := a.admin.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if a.pool != nil {
+		a.pool.Close()
+	}
+	if err := a.tracer.Shutdown(ctx); err != nil {
+		a.logger.Warn("flush spans", logger.Err(err))
+	}
 	return nil
 }
+
+// newTracer creates the tracer of the exporter: "otlp", "stdout" or none.
+func newTracer(exporter, endpoint, serviceName string, log *slog.Logger) (*tracing.Tracer, error) {
+	switch exporter {
+	case "":
+		return nil, nil
+	case config.TracingOTLP:
+		client := &http.Client{Timeout: DefaultTracingExportTimeout}
+		return tracing.NewTracer(tracing.NewOTLPExporter(endpoint, serviceName, client), log), nil
+	case config.TracingStdout:
+		return tracing.NewTracer(tracing.NewStdoutExporter(os.Stdout), log), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+}
+
+// accessLogMiddleware writes the access log to stdout unless the format is "off".
+// The client address is taken from X-Forwarded-For when the request comes
+// from one of the trusted proxies.
+func accessLogMiddleware(format string, trustedProxies []string, next http.Handler) (http.Handler, error) {
+	if format == config.AccessLogOff {
+		return next, nil
+	}
+	f, err := middleware.ParseAccessLogFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("ACCESS_LOG_FORMAT: %w", err)
+	}
+	identifier, err := clientid.NewIdentifier("", trustedProxies, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ACCESS_LOG_TRUSTED_PROXIES: %w", err)
+	}
+	return middleware.AccessLogMiddleware(os.Stdout, f, identifier.ClientIP, next), nil
+}
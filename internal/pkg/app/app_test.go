@@ -0,0 +1,55 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apoldev/go-http/internal/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestApp creates the application of cfg listening on a random port.
+func newTestApp(t *testing.T, cfg *config.Config, reload func() (*config.Config, error)) *App {
+	t.Helper()
+	cfg.Server.Addr = "127.0.0.1:0"
+	cfg.Log.AccessFormat = config.AccessLogOff
+	a, err := New(cfg, reload)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		a.ln.Close()
+		if a.adminLn != nil {
+			a.adminLn.Close()
+		}
+	})
+	return a
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestNew_Metrics(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		a := newTestApp(t, config.Default(), nil)
+
+		w := get(a.srv.Handler, "/metrics")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "app_shutting_down")
+		// the other operational endpoints need the admin address
+		require.Equal(t, http.StatusNotFound, get(a.srv.Handler, "/admin/crawls").Code)
+	})
+
+	t.Run("admin_addr", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.Server.AdminAddr = "127.0.0.1:0"
+		a := newTestApp(t, cfg, nil)
+
+		require.Equal(t, http.StatusNotFound, get(a.srv.Handler, "/metrics").Code)
+		w := get(a.admin.Handler, "/metrics")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Contains(t, w.Body.String(), "app_shutting_down")
+	})
+}
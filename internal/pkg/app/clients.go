@@ -0,0 +1,60 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/lib/clientid"
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/metrics"
+	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/apoldev/go-http/internal/pkg/config"
+)
+
+// clientLimitMiddleware creates the middleware limiting every client by its tier.
+func clientLimitMiddleware(
+	cl *config.ClientLimits, rejected *metrics.Counter,
+) (func(next http.Handler) http.Handler, error) {
+	identifier, err := clientid.NewIdentifier(cl.APIKeyHeader, cl.TrustedProxies, cl.APIKeys, cl.Subjects)
+	if err != nil {
+		return nil, err
+	}
+
+	limiters := limiter.NewKeyed(cl.MaxClients, cl.IdleTTL.Std())
+	newLimiter := func(tier string) limiter.Limiter {
+		t := cl.Tiers[tier]
+		var chain []limiter.Limiter
+		if t.Concurrency > 0 {
+			chain = append(chain, limiter.NewAtomLimiter(t.Concurrency))
+		}
+		if t.RPM > 0 {
+			chain = append(chain, limiter.NewTokenBucket(t.RPM, time.Minute, max(t.Burst, 1)))
+		}
+		return countedLimiter{Limiter: limiter.NewChain(chain...), rejected: rejected}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return middleware.ClientLimitMiddleware(identifier, limiters, newLimiter, next)
+	}, nil
+}
+
+// tierPriorities returns the priorities of the tiers that have one.
+func tierPriorities(cl *config.ClientLimits) (map[string]limiter.Priority, error) {
+	priorities := make(map[string]limiter.Priority)
+	if cl == nil {
+		return priorities, nil
+	}
+	for name, tier := range cl.Tiers {
+		if tier.Priority == "" {
+			continue
+		}
+		p, err := limiter.ParsePriority(strings.TrimSpace(tier.Priority))
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %w", name, err)
+		}
+		priorities[name] = p
+	}
+	return priorities, nil
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/apoldev/go-http/internal/app/breaker"
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/pkg/config"
+)
+
+// NewCrawler creates a crawler with the limits of the configuration, to crawl
+// without the server. CRAWLER_POOL_SIZE is ignored: every batch runs its own
+// workers, the shared pool is only worth it across concurrent requests.
+func NewCrawler(cfg *config.Config, log *slog.Logger) *crawler.Service {
+	cc := cfg.Crawler
+	opts := crawlerOptions(cc, newBreakers(cc.Breaker))
+	if cc.MaxOutbound > 0 {
+		opts = append(opts, crawler.WithOutbound(newOutbound(cc)))
+	}
+	return crawler.New(cc.MaxWorkers, int(cc.RequestTimeout.Std().Milliseconds()), http.DefaultClient, log, opts...)
+}
+
+// crawlerOptions are the crawler features set by the configuration.
+func crawlerOptions(cc config.Crawler, breakers *breaker.Set) []crawler.Option {
+	opts := []crawler.Option{
+		crawler.WithHosts(crawler.HostPolicy{Allowed: cc.AllowedHosts, Blocked: cc.BlockedHosts}),
+		crawler.WithHedging(crawler.HedgeConfig{
+			Delay:         cc.Hedge.Delay.Std(),
+			Percentile:    float64(cc.Hedge.Percentile),
+			BudgetPercent: float64(cc.Hedge.BudgetPercent),
+		}),
+		crawler.WithAdaptiveConcurrency(crawler.ConcurrencyConfig{
+			Initial:       cc.MaxWorkers,
+			Min:           cc.Adaptive.MinWorkers,
+			Max:           cc.Adaptive.MaxWorkers,
+			HostMin:       cc.Adaptive.HostMinWorkers,
+			HostMax:       cc.Adaptive.HostMaxWorkers,
+			LatencyTarget: cc.Adaptive.LatencyTarget.Std(),
+		}),
+	}
+	if cc.Breaker.FailureRatePct > 0 {
+		opts = append(opts, crawler.WithBreakers(breakers))
+	}
+	return opts
+}
+
+func newBreakers(cfg config.Breaker) *breaker.Set {
+	return breaker.NewSet(breaker.Config{
+		FailureRate:      float64(cfg.FailureRatePct) / 100,
+		MinRequests:      cfg.MinRequests,
+		Window:           cfg.Window.Std(),
+		CoolDown:         cfg.CoolDown.Std(),
+		HalfOpenRequests: cfg.HalfOpenRequests,
+	})
+}
+
+func newOutbound(cc config.Crawler) *crawler.Outbound {
+	return crawler.NewOutbound(crawler.OutboundConfig{
+		Limit:     cc.MaxOutbound,
+		QueueSize: cc.OutboundQueueSize,
+		MaxWait:   cc.OutboundQueueTimeout.Std(),
+	})
+}
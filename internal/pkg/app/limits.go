@@ -0,0 +1,160 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/apoldev/go-http/internal/pkg/config"
+)
+
+type limitsConfig struct {
+	mode                string
+	maxConnections      int
+	queueSize           int
+	queueTimeout        time.Duration
+	reservedHigh        int
+	reservedNormal      int
+	trustPriorityHeader bool
+	rateLimitRPM        int
+	rateLimitBurst      int
+	rateLimitAlgorithm  string
+	clientLimits        *config.ClientLimits
+	shed                limiter.ShedConfig
+	shedRetryAfter      time.Duration
+	metrics             *limiterMetrics
+}
+
+// newLimitMiddleware chains the limiters in front of the crawl handler: load
+// shedding, per-client limits, then the global rate limit, then the global
+// concurrency limit. In priority mode shedding comes after the client limits,
+// where the priority is known, and lets high priority requests on to the
+// priority limiter with its reserved slots; the rate limit applies to all of
+// them. The concurrency limiter is returned to be resized on reload.
+func newLimitMiddleware(cfg limitsConfig) (func(next http.Handler) http.Handler, limiter.Resizable, error) {
+	cl := cfg.clientLimits
+	var shedder *limiter.LoadShedder
+	observeWait := func(time.Duration) {}
+	if cfg.shed.Enabled() {
+		shedder = limiter.NewLoadShedder(cfg.shed)
+		observeWait = shedder.ObserveQueueLatency
+	}
+	concurrencyRejected := cfg.metrics.rejections.With(limiterConcurrency)
+
+	var limitMiddleware func(next http.Handler) http.Handler
+	var capacity limiter.Resizable
+	// classify is set in priority mode only
+	var classify func(r *http.Request) limiter.Priority
+	switch cfg.mode {
+	case config.LimiterModeReject:
+		atom := limiter.NewAtomLimiter(cfg.maxConnections)
+		cfg.metrics.slots(limiterConcurrency, atom)
+		capacity = atom
+		l := countedLimiter{Limiter: atom, rejected: concurrencyRejected}
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return middleware.LimitMiddleware(l, next)
+		}
+	case config.LimiterModeQueue:
+		l := observedQueue{
+			QueueLimiter: limiter.NewQueueLimiter(cfg.maxConnections, cfg.queueSize, cfg.queueTimeout),
+			observe:      observeWait,
+			rejected:     concurrencyRejected,
+		}
+		cfg.metrics.slots(limiterConcurrency, l)
+		capacity = l
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return middleware.QueueLimitMiddleware(l, next)
+		}
+	case config.LimiterModePriority:
+		priorities, err := tierPriorities(cl)
+		if err != nil {
+			return nil, nil, err
+		}
+		l := observedPriorityQueue{
+			PriorityLimiter: limiter.NewPriorityLimiter(cfg.maxConnections, cfg.reservedHigh, cfg.reservedNormal,
+				cfg.queueSize, cfg.queueTimeout),
+			observe:  observeWait,
+			rejected: concurrencyRejected,
+		}
+		cfg.metrics.slots(limiterConcurrency, l)
+		capacity = l
+		classify = middleware.NewPriorityClassifier(priorities, cfg.trustPriorityHeader).Classify
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return middleware.PriorityLimitMiddleware(l, classify, next)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown limiter mode %q", cfg.mode)
+	}
+
+	if cfg.rateLimitRPM > 0 {
+		r, err := newRateLimiter(cfg.rateLimitAlgorithm, cfg.rateLimitRPM, cfg.rateLimitBurst)
+		if err != nil {
+			return nil, nil, err
+		}
+		rl := countedLimiter{Limiter: r, rejected: cfg.metrics.rejections.With(limiterRate)}
+		cfg.metrics.states[limiterRate] = rl
+		// the rate limit is checked first, so that a rejected request never holds a slot
+		concurrencyMiddleware := limitMiddleware
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return middleware.LimitMiddleware(rl, concurrencyMiddleware(next))
+		}
+	}
+
+	var shedMiddleware func(next http.Handler) http.Handler
+	if shedder != nil {
+		counted := countedShedder{LoadShedder: shedder, rejected: cfg.metrics.rejections.With(limiterShed)}
+		shedMiddleware = func(next http.Handler) http.Handler {
+			return middleware.LoadShedMiddleware(counted, cfg.shedRetryAfter, next)
+		}
+	}
+	if shedMiddleware != nil && classify != nil {
+		// the priority comes from the client's tier, known only after the client
+		// limits; high priority requests are bounded by the priority limiter instead
+		shed := shedMiddleware
+		shedMiddleware = func(next http.Handler) http.Handler {
+			return middleware.PriorityBypassMiddleware(classify, shed, next)
+		}
+		limitedMiddleware := limitMiddleware
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return shedMiddleware(limitedMiddleware(next))
+		}
+	}
+
+	if cl != nil {
+		clientMiddleware, err := clientLimitMiddleware(cl, cfg.metrics.rejections.With(limiterClient))
+		if err != nil {
+			return nil, nil, err
+		}
+		// one noisy client is stopped before it takes the global capacity
+		globalMiddleware := limitMiddleware
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return clientMiddleware(globalMiddleware(next))
+		}
+	}
+
+	if shedMiddleware != nil && classify == nil {
+		// shedding is the cheapest check and protects all the limiters behind it
+		limitedMiddleware := limitMiddleware
+		limitMiddleware = func(next http.Handler) http.Handler {
+			return shedMiddleware(limitedMiddleware(next))
+		}
+	}
+
+	return limitMiddleware, capacity, nil
+}
+
+// newRateLimiter creates a rate limiter allowing rpm requests per minute.
+func newRateLimiter(algorithm string, rpm, burst int) (limiter.Limiter, error) {
+	switch algorithm {
+	case config.RateLimitTokenBucket:
+		return limiter.NewTokenBucket(rpm, time.Minute, burst), nil
+	case config.RateLimitSlidingWindowLog:
+		return limiter.NewSlidingWindowLog(rpm, time.Minute), nil
+	case config.RateLimitSlidingWindowCounter:
+		return limiter.NewSlidingWindowCounter(rpm, time.Minute), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+}
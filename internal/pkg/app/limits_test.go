@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/metrics"
+	"github.com/apoldev/go-http/internal/app/middleware"
+	"github.com/apoldev/go-http/internal/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimitMiddleware_Priority(t *testing.T) {
+	limitMiddleware, _, err := newLimitMiddleware(limitsConfig{
+		mode:                config.LimiterModePriority,
+		maxConnections:      2,
+		queueTimeout:        time.Second,
+		reservedHigh:        1,
+		trustPriorityHeader: true,
+		rateLimitRPM:        2,
+		rateLimitBurst:      2,
+		rateLimitAlgorithm:  config.RateLimitTokenBucket,
+		// any process has more goroutines, so everything is shed
+		shed:           limiter.ShedConfig{MaxGoroutines: 1, Interval: time.Minute},
+		shedRetryAfter: time.Second,
+		metrics:        newLimiterMetrics(metrics.NewRegistry()),
+	})
+	require.NoError(t, err)
+	handler := limitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(priority string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set(middleware.HeaderPriority, priority)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusServiceUnavailable, serve("normal"))
+	// high priority requests aren't shed, but the rate limit still holds them back
+	require.Equal(t, http.StatusOK, serve("high"))
+	require.Equal(t, http.StatusOK, serve("high"))
+	require.Equal(t, http.StatusTooManyRequests, serve("high"))
+}
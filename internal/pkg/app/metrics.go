@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/apoldev/go-http/internal/app/limiter"
+	"github.com/apoldev/go-http/internal/app/metrics"
+)
+
+// Limiter names used as metric labels.
+const (
+	limiterClient       = "client"
+	limiterRate         = "rate"
+	limiterConcurrency  = "concurrency"
+	limiterShed         = "shed"
+	limiterInflightURLs = "inflight_urls"
+	limiterOutbound     = "outbound"
+)
+
+type limiterMetrics struct {
+	inUse      *metrics.GaugeVec
+	limit      *metrics.GaugeVec
+	rejections *metrics.CounterVec
+	// states are the global limiters by name, for the admin endpoint.
+	states map[string]limiter.Stater
+}
+
+func newLimiterMetrics(reg *metrics.Registry) *limiterMetrics {
+	return &limiterMetrics{
+		inUse:      reg.NewGaugeVec("limiter_slots_in_use", "Number of limiter slots taken.", "limiter"),
+		limit:      reg.NewGaugeVec("limiter_slots_limit", "Number of limiter slots.", "limiter"),
+		rejections: reg.NewCounterVec("limiter_rejections_total", "Number of requests rejected by a limiter.", "limiter"),
+		states:     make(map[string]limiter.Stater),
+	}
+}
+
+// slots reports the slots of a concurrency limiter.
+func (m *limiterMetrics) slots(name string, s limiter.Stater) {
+	m.states[name] = s
+	m.inUse.Func(func() float64 {
+		st := s.State()
+		return float64(st.Limit - st.Remaining)
+	}, name)
+	m.limit.Func(func() float64 { return float64(s.State().Limit) }, name)
+}
+
+// staterFunc adapts a function to limiter.Stater.
+type staterFunc func() limiter.State
+
+func (f staterFunc) State() limiter.State {
+	return f()
+}
+
+// rejected reports whether err is a rejection rather than the client having gone away.
+func rejected(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled)
+}
+
+// countedLimiter counts the requests a limiter rejects.
+type countedLimiter struct {
+	limiter.Limiter
+	rejected *metrics.Counter
+}
+
+func (l countedLimiter) Take() bool {
+	if l.Limiter.Take() {
+		return true
+	}
+	l.rejected.Inc()
+	return false
+}
+
+// State is the state of the wrapped limiter, the zero State when it has none.
+func (l countedLimiter) State() limiter.State {
+	if s, ok := l.Limiter.(limiter.Stater); ok {
+		return s.State()
+	}
+	return limiter.State{}
+}
+
+// countedAdmission counts the requests rejected for the number of urls in flight.
+type countedAdmission struct {
+	*limiter.Weighted
+	rejected *metrics.Counter
+}
+
+func (a countedAdmission) TryAcquire(n int64) bool {
+	if a.Weighted.TryAcquire(n) {
+		return true
+	}
+	a.rejected.Inc()
+	return false
+}
+
+// countedShedder counts the requests shed by the load shedder.
+type countedShedder struct {
+	*limiter.LoadShedder
+	rejected *metrics.Counter
+}
+
+func (s countedShedder) Check() error {
+	err := s.LoadShedder.Check()
+	if err != nil {
+		s.rejected.Inc()
+	}
+	return err
+}
+
+// observedQueue reports the time spent waiting for a slot to the load shedder
+// and counts rejections.
+type observedQueue struct {
+	*limiter.QueueLimiter
+	observe  func(time.Duration)
+	rejected *metrics.Counter
+}
+
+func (q observedQueue) Acquire(ctx context.Context) error {
+	start := time.Now()
+	err := q.QueueLimiter.Acquire(ctx)
+	q.observe(time.Since(start))
+	if rejected(ctx, err) {
+		q.rejected.Inc()
+	}
+	return err
+}
+
+type observedPriorityQueue struct {
+	*limiter.PriorityLimiter
+	observe  func(time.Duration)
+	rejected *metrics.Counter
+}
+
+func (q observedPriorityQueue) Acquire(ctx context.Context, p limiter.Priority) error {
+	start := time.Now()
+	err := q.PriorityLimiter.Acquire(ctx, p)
+	q.observe(time.Since(start))
+	if rejected(ctx, err) {
+		q.rejected.Inc()
+	}
+	return err
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/apoldev/go-http/internal/app/crawler"
+	"github.com/apoldev/go-http/internal/pkg/config"
+	"github.com/apoldev/go-http/pkg/logger"
+)
+
+// reloadable are the settings applied on SIGHUP, changes of the others are
+// logged and wait for a restart.
+var reloadable = []string{
+	"SERVER_MAX_CONNECTIONS",
+	"CRAWLER_MAX_URLS",
+	"CRAWLER_MAX_WORKERS",
+	"CRAWLER_REQUEST_TIMEOUT_MS",
+	"CRAWLER_ALLOWED_HOSTS",
+	"CRAWLER_BLOCKED_HOSTS",
+}
+
+// reloadConfig reads the configuration again and applies the reloadable
+// settings. Crawls in flight keep the worker count and request timeout they
+// have started with and their URLs have passed the host lists, only the
+// redirects they follow from now on are checked against the new lists. A
+// configuration that fails to load or validate leaves everything as it is.
+func (a *App) reloadConfig() {
+	if a.reload == nil {
+		a.logger.Warn("config reload is not supported")
+		return
+	}
+	next, err := a.reload()
+	if err != nil {
+		a.logger.Error("config reload failed", logger.Err(err))
+		return
+	}
+
+	cfg := *a.cfg
+	cfg.Limiter.MaxConnections = next.Limiter.MaxConnections
+	cfg.Crawler.MaxURLs = next.Crawler.MaxURLs
+	cfg.Crawler.MaxWorkers = next.Crawler.MaxWorkers
+	cfg.Crawler.RequestTimeout = next.Crawler.RequestTimeout
+	cfg.Crawler.AllowedHosts = next.Crawler.AllowedHosts
+	cfg.Crawler.BlockedHosts = next.Crawler.BlockedHosts
+	// the new values must also fit the settings that stay, e.g. the reserved capacity
+	if err := cfg.Validate(); err != nil {
+		a.logger.Error("config reload failed", logger.Err(err))
+		return
+	}
+
+	var applied, ignored []string
+	for _, key := range config.Changed(a.cfg, next) {
+		if slices.Contains(reloadable, key) {
+			applied = append(applied, key)
+		} else {
+			ignored = append(ignored, key)
+		}
+	}
+
+	// only what has changed, e.g. setting the worker count again would reset
+	// the adaptive concurrency
+	for _, key := range applied {
+		a.apply(key, &cfg)
+	}
+	a.cfg = &cfg
+	a.adminHandler.SetConfig(cfg.Redacted())
+
+	a.logger.Info("config reloaded", slog.Any("changed", applied))
+	if len(ignored) > 0 {
+		a.logger.Warn("config changes need a restart", slog.Any("settings", ignored))
+	}
+}
+
+// apply puts a reloadable setting of cfg in effect.
+func (a *App) apply(key string, cfg *config.Config) {
+	switch key {
+	case "SERVER_MAX_CONNECTIONS":
+		a.capacity.SetLimit(cfg.Limiter.MaxConnections)
+	case "CRAWLER_MAX_URLS":
+		a.crawlHandler.SetMaxURLs(cfg.Crawler.MaxURLs)
+	case "CRAWLER_MAX_WORKERS":
+		a.crawler.SetWorkerCount(cfg.Crawler.MaxWorkers)
+	case "CRAWLER_REQUEST_TIMEOUT_MS":
+		a.crawler.SetRequestTimeout(cfg.Crawler.RequestTimeout.Std())
+	case "CRAWLER_ALLOWED_HOSTS", "CRAWLER_BLOCKED_HOSTS":
+		a.crawler.SetHosts(crawler.HostPolicy{Allowed: cfg.Crawler.AllowedHosts, Blocked: cfg.Crawler.BlockedHosts})
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"bytes"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apoldev/go-http/internal/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedCapacity records the limits set on reload.
+type recordedCapacity struct {
+	limits []int
+}
+
+func (c *recordedCapacity) SetLimit(n int) {
+	c.limits = append(c.limits, n)
+}
+
+// newReloadApp creates an application configured by a file, which the test
+// rewrites before every reload, and returns the log of the reloads.
+func newReloadApp(t *testing.T, content string) (*App, *recordedCapacity, func(content string), *bytes.Buffer) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write(content)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	loader := config.NewLoader(fs)
+	require.NoError(t, fs.Parse([]string{"--config", path}))
+	env := map[string]string{"ADDR": "127.0.0.1:0", "ACCESS_LOG_FORMAT": config.AccessLogOff}
+	load := func() (*config.Config, error) {
+		return loader.Load(func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		})
+	}
+	cfg, err := load()
+	require.NoError(t, err)
+
+	a := newTestApp(t, cfg, load)
+	capacity := &recordedCapacity{}
+	a.capacity = capacity
+	var logs bytes.Buffer
+	a.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	return a, capacity, write, &logs
+}
+
+func TestReloadConfig(t *testing.T) {
+	const initial = `{"limiter": {"max_connections": 5}, "crawler": {"max_workers": 4, "max_batch_timeout": "30s"}}`
+
+	t.Run("invalid_file", func(t *testing.T) {
+		a, capacity, write, logs := newReloadApp(t, initial)
+		old := a.cfg
+
+		write(`{"limiter": {"max_connections": 10}`)
+		a.reloadConfig()
+		write(`{"limiter": {"max_connections": 10}, "crawler": {"max_workers": 0}}`)
+		a.reloadConfig()
+
+		require.Same(t, old, a.cfg)
+		require.Empty(t, capacity.limits)
+		require.Equal(t, 2, bytes.Count(logs.Bytes(), []byte(`"msg":"config reload failed"`)))
+	})
+
+	t.Run("only_changed", func(t *testing.T) {
+		a, capacity, write, logs := newReloadApp(t, initial)
+
+		write(`{"limiter": {"max_connections": 10}, "crawler": {"max_workers": 4, "max_batch_timeout": "30s"}}`)
+		a.reloadConfig()
+		require.Equal(t, []int{10}, capacity.limits)
+		require.Contains(t, logs.String(), `"changed":["SERVER_MAX_CONNECTIONS"]`)
+
+		logs.Reset()
+		write(`{"limiter": {"max_connections": 10}, "crawler": {"max_workers": 8, "max_batch_timeout": "30s"}}`)
+		a.reloadConfig()
+		// the limiter is left alone when only the workers change
+		require.Equal(t, []int{10}, capacity.limits)
+		require.Contains(t, logs.String(), `"changed":["CRAWLER_MAX_WORKERS"]`)
+		require.Equal(t, 10, a.cfg.Limiter.MaxConnections)
+		require.Equal(t, 8, a.cfg.Crawler.MaxWorkers)
+	})
+
+	t.Run("needs_restart", func(t *testing.T) {
+		a, capacity, write, logs := newReloadApp(t, initial)
+
+		write(`{"limiter": {"max_connections": 5}, "crawler": {"max_workers": 4, "max_batch_timeout": "10s"}}`)
+		a.reloadConfig()
+
+		require.Empty(t, capacity.limits)
+		require.Equal(t, 30*time.Second, a.cfg.Crawler.MaxBatchTimeout.Std())
+		require.Contains(t, logs.String(),
+			`"msg":"config changes need a restart","settings":["CRAWLER_MAX_BATCH_TIMEOUT_MS"]`)
+	})
+}
@@ -0,0 +1,368 @@
+// Package config is the configuration of the service. It is built from the
+// defaults, a JSON config file, env and command-line flags, each overriding the
+// previous, and validated as a whole, see Loader.
+//
+// Every setting has an env name, the env tag, and a flag named after it, e.g.
+// SERVER_MAX_CONNECTIONS and --server-max-connections. Durations are integer
+// milliseconds, as the _MS suffix of their names says, or Go durations such as
+// "1.5s"; lists are comma separated.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// LimiterModeReject answers 429 as soon as all slots are taken.
+	LimiterModeReject = "reject"
+	// LimiterModeQueue lets requests wait for a slot in a bounded queue.
+	LimiterModeQueue = "queue"
+	// LimiterModePriority is LimiterModeQueue with a queue and reserved capacity per priority.
+	LimiterModePriority = "priority"
+)
+
+const (
+	RateLimitTokenBucket          = "token_bucket"
+	RateLimitSlidingWindowLog     = "sliding_window_log"
+	RateLimitSlidingWindowCounter = "sliding_window_counter"
+)
+
+const (
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+)
+
+// Access log formats, see middleware.AccessLogFormat.
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+	// AccessLogOff turns the access log off.
+	AccessLogOff = "off"
+)
+
+// Priorities of the client tiers in the priority limiter mode.
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// DefaultTier is the tier of the clients without a tier of their own, it must
+// be in CLIENT_LIMITS.
+const DefaultTier = "default"
+
+type Config struct {
+	Log       Log       `json:"log"`
+	Server    Server    `json:"server"`
+	Readiness Readiness `json:"readiness"`
+	Limiter   Limiter   `json:"limiter"`
+	Shed      Shed      `json:"shed"`
+	Crawler   Crawler   `json:"crawler"`
+	Tracing   Tracing   `json:"tracing"`
+	// Per-client limits, a JSON object in env and flags too.
+	ClientLimits *ClientLimits `json:"client_limits" env:"CLIENT_LIMITS"`
+}
+
+type Log struct {
+	// Log format: text or json.
+	Format string `json:"format" env:"LOG_FORMAT"`
+	// Log level: debug, info, warn or error.
+	Level string `json:"level" env:"LOG_LEVEL"`
+	// Access log format: common, combined, json or off.
+	AccessFormat string `json:"access_format" env:"ACCESS_LOG_FORMAT"`
+	// Proxies trusted to set X-Forwarded-For in the access log, IPs and CIDRs.
+	AccessTrustedProxies []string `json:"access_trusted_proxies" env:"ACCESS_LOG_TRUSTED_PROXIES"`
+}
+
+type Server struct {
+	// Address of the crawl API.
+	Addr string `json:"addr" env:"ADDR"`
+	// Address of the admin listener, none if empty.
+	AdminAddr string `json:"admin_addr" env:"ADMIN_ADDR"`
+	// Time readiness fails before the server shuts down.
+	DrainDelay Duration `json:"drain_delay" env:"SERVER_DRAIN_DELAY_MS"`
+	// Max URLs being crawled by all requests, 0 means no limit.
+	MaxInflightURLs int `json:"max_inflight_urls" env:"SERVER_MAX_INFLIGHT_URLS"`
+}
+
+type Readiness struct {
+	// Upstreams that must be reachable for readiness.
+	ProbeURLs []string `json:"probe_urls" env:"READINESS_PROBE_URLS"`
+	// Timeout of a readiness probe.
+	ProbeTimeout Duration `json:"probe_timeout" env:"READINESS_PROBE_TIMEOUT_MS"`
+}
+
+type Limiter struct {
+	// Concurrency limiter mode: reject, queue or priority.
+	Mode string `json:"mode" env:"SERVER_LIMITER_MODE"`
+	// Max crawl requests served at once.
+	MaxConnections int `json:"max_connections" env:"SERVER_MAX_CONNECTIONS"`
+	// Max requests waiting for a slot.
+	QueueSize int `json:"queue_size" env:"SERVER_LIMITER_QUEUE_SIZE"`
+	// Max wait for a slot.
+	QueueTimeout Duration `json:"queue_timeout" env:"SERVER_LIMITER_QUEUE_TIMEOUT_MS"`
+	// Max requests per minute, 0 means no limit.
+	RateLimitRPM int `json:"rate_limit_rpm" env:"SERVER_RATE_LIMIT_RPM"`
+	// Token bucket burst, 0 means the rpm.
+	RateLimitBurst int `json:"rate_limit_burst" env:"SERVER_RATE_LIMIT_BURST"`
+	// Rate limit algorithm: token_bucket, sliding_window_log or sliding_window_counter.
+	RateLimitAlgorithm string `json:"rate_limit_algorithm" env:"SERVER_RATE_LIMIT_ALGORITHM"`
+	// Slots reserved for high priority requests.
+	ReservedHigh int `json:"reserved_high" env:"SERVER_PRIORITY_RESERVED_HIGH"`
+	// Slots reserved for normal and high priority requests.
+	ReservedNormal int `json:"reserved_normal" env:"SERVER_PRIORITY_RESERVED_NORMAL"`
+	// Let X-Priority raise the priority.
+	TrustHeader bool `json:"trust_header" env:"SERVER_PRIORITY_TRUST_HEADER"`
+}
+
+type Shed struct {
+	// Shed load above this many goroutines, 0 means off.
+	MaxGoroutines int `json:"max_goroutines" env:"SERVER_SHED_MAX_GOROUTINES"`
+	// Shed load above this heap size, 0 means off.
+	MaxHeapMB int `json:"max_heap_mb" env:"SERVER_SHED_MAX_HEAP_MB"`
+	// Shed load above this queue wait, 0 means off.
+	MaxQueueLatency Duration `json:"max_queue_latency" env:"SERVER_SHED_MAX_QUEUE_LATENCY_MS"`
+	// Retry-After of shed requests.
+	RetryAfter Duration `json:"retry_after" env:"SERVER_SHED_RETRY_AFTER_MS"`
+}
+
+type Crawler struct {
+	// Max URLs in a request.
+	MaxURLs int `json:"max_urls" env:"CRAWLER_MAX_URLS"`
+	// Workers of a request.
+	MaxWorkers int `json:"max_workers" env:"CRAWLER_MAX_WORKERS"`
+	// Timeout of a URL fetch.
+	RequestTimeout Duration `json:"request_timeout" env:"CRAWLER_REQUEST_TIMEOUT_MS"`
+	// Max deadline a client may ask for a request.
+	MaxBatchTimeout Duration `json:"max_batch_timeout" env:"CRAWLER_MAX_BATCH_TIMEOUT_MS"`
+	// Max timeout a client may ask for a URL.
+	MaxURLTimeout Duration `json:"max_url_timeout" env:"CRAWLER_MAX_URL_TIMEOUT_MS"`
+	// Workers shared by all requests, 0 means the workers of every request are
+	// its own goroutines.
+	PoolSize int `json:"pool_size" env:"CRAWLER_POOL_SIZE"`
+	// Max fetches in flight, 0 means no limit.
+	MaxOutbound int `json:"max_outbound" env:"CRAWLER_MAX_OUTBOUND"`
+	// Max fetches waiting for the outbound limit.
+	OutboundQueueSize int `json:"outbound_queue_size" env:"CRAWLER_OUTBOUND_QUEUE_SIZE"`
+	// Max wait for the outbound limit.
+	OutboundQueueTimeout Duration `json:"outbound_queue_timeout" env:"CRAWLER_OUTBOUND_QUEUE_TIMEOUT_MS"`
+	// Hosts that may be crawled, with their subdomains; all if empty.
+	AllowedHosts []string `json:"allowed_hosts" env:"CRAWLER_ALLOWED_HOSTS"`
+	// Hosts that may not be crawled, with their subdomains.
+	BlockedHosts []string `json:"blocked_hosts" env:"CRAWLER_BLOCKED_HOSTS"`
+	Hedge        Hedge    `json:"hedge"`
+	Breaker      Breaker  `json:"breaker"`
+	Adaptive     Adaptive `json:"adaptive"`
+}
+
+type Hedge struct {
+	// Fixed delay of a hedged request, 0 means off.
+	Delay Duration `json:"delay" env:"CRAWLER_HEDGE_DELAY_MS"`
+	// Latency percentile to hedge after, 0 means off.
+	Percentile int `json:"percentile" env:"CRAWLER_HEDGE_PERCENTILE"`
+	// Max hedged requests, percent of all.
+	BudgetPercent int `json:"budget_percent" env:"CRAWLER_HEDGE_BUDGET_PERCENT"`
+}
+
+type Breaker struct {
+	// Failure rate that opens the breaker, 0 means off.
+	FailureRatePct int `json:"failure_rate_pct" env:"CRAWLER_BREAKER_FAILURE_RATE_PCT"`
+	// Requests in the window before the breaker may open.
+	MinRequests int `json:"min_requests" env:"CRAWLER_BREAKER_MIN_REQUESTS"`
+	// Window of the failure rate.
+	Window Duration `json:"window" env:"CRAWLER_BREAKER_WINDOW_MS"`
+	// Time the breaker stays open.
+	CoolDown Duration `json:"cool_down" env:"CRAWLER_BREAKER_COOLDOWN_MS"`
+	// Trial requests of a half-open breaker.
+	HalfOpenRequests int `json:"half_open_requests" env:"CRAWLER_BREAKER_HALF_OPEN_REQUESTS"`
+}
+
+type Adaptive struct {
+	// Min workers of a request.
+	MinWorkers int `json:"min_workers" env:"CRAWLER_ADAPTIVE_MIN_WORKERS"`
+	// Max workers of a request, 0 means off.
+	MaxWorkers int `json:"max_workers" env:"CRAWLER_ADAPTIVE_MAX_WORKERS"`
+	// Min concurrent fetches of a host.
+	HostMinWorkers int `json:"host_min_workers" env:"CRAWLER_ADAPTIVE_HOST_MIN_WORKERS"`
+	// Max concurrent fetches of a host.
+	HostMaxWorkers int `json:"host_max_workers" env:"CRAWLER_ADAPTIVE_HOST_MAX_WORKERS"`
+	// Fetch latency to keep hosts under.
+	LatencyTarget Duration `json:"latency_target" env:"CRAWLER_ADAPTIVE_LATENCY_TARGET_MS"`
+}
+
+type Tracing struct {
+	// Span exporter: otlp, stdout or none if empty.
+	Exporter string `json:"exporter" env:"TRACING_EXPORTER"`
+	// OTLP/HTTP traces endpoint.
+	OTLPEndpoint string `json:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	// Service name of the spans.
+	ServiceName string `json:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+// Defaults of the client limits, set when the JSON omits them.
+const (
+	DefaultClientLimitsMaxClients = 10000
+	DefaultClientLimitsIdleTTL    = time.Minute * 10
+)
+
+// ClientLimits configures per-client limits, e.g.
+//
+//	{"trusted_proxies": ["10.0.0.0/8"], "api_keys": {"secret": "gold"},
+//	 "tiers": {"default": {"rpm": 60, "concurrency": 2}, "gold": {"rpm": 600, "burst": 50, "concurrency": 10}}}
+type ClientLimits struct {
+	APIKeyHeader   string   `json:"api_key_header"`
+	TrustedProxies []string `json:"trusted_proxies"`
+	// APIKeys maps API keys to tiers.
+	APIKeys map[string]string `json:"api_keys"`
+	// Subjects maps mTLS client certificate subjects to tiers.
+	Subjects   map[string]string     `json:"subjects"`
+	Tiers      map[string]TierLimits `json:"tiers"`
+	MaxClients int                   `json:"max_clients"`
+	// Time after which an idle client is forgotten.
+	IdleTTL Duration `json:"idle_ttl"`
+}
+
+// TierLimits are the limits of every client in a tier, zero means no limit.
+// Priority is the admission priority of the tier in the priority limiter mode.
+type TierLimits struct {
+	RPM         int    `json:"rpm"`
+	Burst       int    `json:"burst"`
+	Concurrency int    `json:"concurrency"`
+	Priority    string `json:"priority"`
+}
+
+// Default returns the configuration used for the settings that are not set.
+func Default() *Config {
+	return &Config{
+		Log: Log{
+			Format:       "text",
+			Level:        "info",
+			AccessFormat: AccessLogCombined,
+		},
+		Server: Server{
+			Addr: ":8080",
+		},
+		Readiness: Readiness{
+			ProbeTimeout: Duration(time.Second),
+		},
+		Limiter: Limiter{
+			Mode:               LimiterModeReject,
+			MaxConnections:     1,
+			QueueSize:          100,
+			QueueTimeout:       Duration(time.Second),
+			RateLimitAlgorithm: RateLimitTokenBucket,
+		},
+		Shed: Shed{
+			RetryAfter: Duration(time.Second),
+		},
+		Crawler: Crawler{
+			MaxURLs:              20,
+			MaxWorkers:           4,
+			PoolSize:             64,
+			RequestTimeout:       Duration(time.Second),
+			MaxBatchTimeout:      Duration(30 * time.Second),
+			MaxURLTimeout:        Duration(10 * time.Second),
+			OutboundQueueSize:    1000,
+			OutboundQueueTimeout: Duration(time.Second),
+			Hedge: Hedge{
+				BudgetPercent: 10,
+			},
+			Breaker: Breaker{
+				MinRequests:      10,
+				Window:           Duration(10 * time.Second),
+				CoolDown:         Duration(5 * time.Second),
+				HalfOpenRequests: 1,
+			},
+			Adaptive: Adaptive{
+				MinWorkers:     1,
+				HostMinWorkers: 1,
+				HostMaxWorkers: 16,
+				LatencyTarget:  Duration(500 * time.Millisecond),
+			},
+		},
+		Tracing: Tracing{
+			OTLPEndpoint: "http://localhost:4318/v1/traces",
+			ServiceName:  "go-http",
+		},
+	}
+}
+
+// Redacted returns a copy of the configuration without secrets: the API keys
+// of the client limits and the credentials of the tracing endpoint.
+func (c *Config) Redacted() *Config {
+	out := *c
+	if c.ClientLimits != nil {
+		cl := *c.ClientLimits
+		if len(cl.APIKeys) > 0 {
+			// the tiers in use are worth seeing, the keys are not
+			cl.APIKeys = make(map[string]string, len(c.ClientLimits.APIKeys))
+			i := 0
+			for _, tier := range c.ClientLimits.APIKeys {
+				i++
+				cl.APIKeys["redacted-"+strconv.Itoa(i)] = tier
+			}
+		}
+		out.ClientLimits = &cl
+	}
+	if u, err := url.Parse(c.Tracing.OTLPEndpoint); err == nil && u.User != nil {
+		u.User = url.User("redacted")
+		out.Tracing.OTLPEndpoint = u.String()
+	}
+	return &out
+}
+
+// Print writes the redacted configuration as JSON, in the format of the config file.
+func (c *Config) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.Redacted())
+}
+
+// Duration is a time.Duration read from integer milliseconds or a Go
+// duration string, and written as a Go duration string.
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// a number of milliseconds
+		s = string(b)
+	}
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+func parseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if ms, err := strconv.Atoi(s); err == nil {
+		return Duration(time.Duration(ms) * time.Millisecond), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, want milliseconds or a duration like 1.5s", s)
+	}
+	return Duration(d), nil
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvFile names the config file in env, the --config flag overrides it.
+const EnvFile = "CONFIG_FILE"
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// setting is a field of Config that has an env name.
+type setting struct {
+	env string
+	v   reflect.Value
+}
+
+// flagName is the command-line flag of a setting, e.g. --server-max-connections.
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// settings lists the settings of c, in the order of the fields.
+func settings(c *Config) []setting {
+	var out []setting
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if env := f.Tag.Get("env"); env != "" {
+				out = append(out, setting{env: env, v: v.Field(i)})
+			} else if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem())
+	return out
+}
+
+// Changed returns the env names of the settings that differ between a and b.
+func Changed(a, b *Config) []string {
+	bs := settings(b)
+	var out []string
+	for i, s := range settings(a) {
+		if !equal(s.v, bs[i].v) {
+			out = append(out, s.env)
+		}
+	}
+	return out
+}
+
+func equal(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		// an empty list in the file is no list in env
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// setValue parses s into v according to the type of v.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := parseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		if err := json.Unmarshal([]byte(s), p.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		v.Set(p)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// Loader builds the configuration. It keeps the flag values, so that the
+// configuration can be loaded again, e.g. when the config file has changed.
+type Loader struct {
+	file        string
+	printConfig bool
+	// flags are the values of the flags set, by env name.
+	flags map[string]string
+}
+
+// NewLoader registers on fs a flag for every setting, --config with the path
+// of the config file and --print-config.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: make(map[string]string)}
+	fs.StringVar(&l.file, "config", "", "JSON config file, overrides "+EnvFile)
+	fs.BoolVar(&l.printConfig, "print-config", false, "print the configuration in effect, secrets redacted, and exit")
+
+	for _, s := range settings(Default()) {
+		env, typ := s.env, s.v.Type()
+		parse := func(v string) error {
+			// fail on malformed values right away, with the flag name in the message
+			if err := setValue(reflect.New(typ).Elem(), v); err != nil {
+				return err
+			}
+			l.flags[env] = v
+			return nil
+		}
+		usage := "overrides " + env
+		if s.v.Kind() == reflect.Bool {
+			fs.BoolFunc(s.flagName(), usage, parse)
+		} else {
+			fs.Func(s.flagName(), usage, parse)
+		}
+	}
+	return l
+}
+
+// PrintConfig reports whether --print-config is set.
+func (l *Loader) PrintConfig() bool {
+	return l.printConfig
+}
+
+// Load builds the configuration from the defaults, the config file, env and
+// the flags, each overriding the previous, and validates it. Empty env values
+// are ignored.
+func (l *Loader) Load(lookupEnv func(key string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	file := l.file
+	if file == "" {
+		file, _ = lookupEnv(EnvFile)
+	}
+	if file != "" {
+		if err := readFile(file, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings(cfg) {
+		if v, ok := lookupEnv(s.env); ok && v != "" {
+			if err := setValue(s.v, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+		if v, ok := l.flags[s.env]; ok {
+			if err := setValue(s.v, v); err != nil {
+				errs = append(errs, fmt.Errorf("--%s: %w", s.flagName(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	cfg.ClientLimits.setDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile reads the JSON config file over cfg. Unknown keys are errors, so
+// that a typo doesn't silently leave a setting at its default.
+func readFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cl *ClientLimits) setDefaults() {
+	if cl == nil {
+		return
+	}
+	if cl.MaxClients == 0 {
+		cl.MaxClients = DefaultClientLimitsMaxClients
+	}
+	if cl.IdleTTL == 0 {
+		cl.IdleTTL = Duration(DefaultClientLimitsIdleTTL)
+	}
+}
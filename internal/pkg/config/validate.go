@@ -0,0 +1,214 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apoldev/go-http/pkg/logger"
+)
+
+// validator collects the problems of a configuration, so that all of them are
+// reported at once.
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, key, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) atLeast(key string, value, min int) {
+	v.check(value >= min, key, "must be at least %d, got %d", min, value)
+}
+
+func (v *validator) between(key string, value, min, max int) {
+	v.check(value >= min && value <= max, key, "must be between %d and %d, got %d", min, max, value)
+}
+
+func (v *validator) positive(key string, d Duration) {
+	v.check(d > 0, key, "must be positive, got %s", d)
+}
+
+func (v *validator) notNegative(key string, d Duration) {
+	v.check(d >= 0, key, "must not be negative, got %s", d)
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	v.check(slices.Contains(allowed, value), key, "must be one of %q, got %q", allowed, value)
+}
+
+func (v *validator) url(key, value string) {
+	u, err := url.Parse(value)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		key, "must be an http or https URL, got %q", value)
+}
+
+// Validate checks the ranges of the settings and the settings that depend on
+// each other.
+func (c *Config) Validate() error {
+	var v validator
+
+	v.oneOf("LOG_FORMAT", c.Log.Format, logger.FormatText, logger.FormatJSON)
+	var level slog.Level
+	v.check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL",
+		"must be debug, info, warn or error, got %q", c.Log.Level)
+	v.oneOf("ACCESS_LOG_FORMAT", c.Log.AccessFormat, AccessLogCommon, AccessLogCombined, AccessLogJSON, AccessLogOff)
+	err := checkNets(c.Log.AccessTrustedProxies)
+	v.check(err == nil, "ACCESS_LOG_TRUSTED_PROXIES", "%v", err)
+
+	v.check(c.Server.Addr != "", "ADDR", "must not be empty")
+	v.check(c.Server.AdminAddr == "" || c.Server.AdminAddr != c.Server.Addr, "ADMIN_ADDR",
+		"must differ from ADDR %q", c.Server.Addr)
+	v.notNegative("SERVER_DRAIN_DELAY_MS", c.Server.DrainDelay)
+	v.atLeast("SERVER_MAX_INFLIGHT_URLS", c.Server.MaxInflightURLs, 0)
+	// a request with max urls could never be admitted
+	v.check(c.Server.MaxInflightURLs == 0 || c.Server.MaxInflightURLs >= c.Crawler.MaxURLs, "SERVER_MAX_INFLIGHT_URLS",
+		"must not be less than CRAWLER_MAX_URLS (%d), got %d", c.Crawler.MaxURLs, c.Server.MaxInflightURLs)
+
+	for _, u := range c.Readiness.ProbeURLs {
+		v.url("READINESS_PROBE_URLS", u)
+	}
+	v.positive("READINESS_PROBE_TIMEOUT_MS", c.Readiness.ProbeTimeout)
+
+	c.Limiter.validate(&v)
+	c.Shed.validate(&v, c.Limiter.Mode)
+	c.Crawler.validate(&v)
+
+	v.oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "", TracingOTLP, TracingStdout)
+	if c.Tracing.Exporter == TracingOTLP {
+		v.url("TRACING_OTLP_ENDPOINT", c.Tracing.OTLPEndpoint)
+		v.check(c.Tracing.ServiceName != "", "TRACING_SERVICE_NAME", "must not be empty")
+	}
+
+	if c.ClientLimits != nil {
+		if err := c.ClientLimits.validate(); err != nil {
+			v.errs = append(v.errs, fmt.Errorf("CLIENT_LIMITS: %w", err))
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (l *Limiter) validate(v *validator) {
+	v.oneOf("SERVER_LIMITER_MODE", l.Mode, LimiterModeReject, LimiterModeQueue, LimiterModePriority)
+	v.atLeast("SERVER_MAX_CONNECTIONS", l.MaxConnections, 1)
+	v.atLeast("SERVER_LIMITER_QUEUE_SIZE", l.QueueSize, 0)
+	if l.Mode != LimiterModeReject {
+		v.positive("SERVER_LIMITER_QUEUE_TIMEOUT_MS", l.QueueTimeout)
+	}
+	v.atLeast("SERVER_RATE_LIMIT_RPM", l.RateLimitRPM, 0)
+	v.atLeast("SERVER_RATE_LIMIT_BURST", l.RateLimitBurst, 0)
+	v.oneOf("SERVER_RATE_LIMIT_ALGORITHM", l.RateLimitAlgorithm,
+		RateLimitTokenBucket, RateLimitSlidingWindowLog, RateLimitSlidingWindowCounter)
+	v.atLeast("SERVER_PRIORITY_RESERVED_HIGH", l.ReservedHigh, 0)
+	v.atLeast("SERVER_PRIORITY_RESERVED_NORMAL", l.ReservedNormal, 0)
+	if l.Mode == LimiterModePriority {
+		v.check(l.ReservedHigh+l.ReservedNormal < l.MaxConnections, "SERVER_PRIORITY_RESERVED_HIGH",
+			"reserved capacity (%d) must be less than SERVER_MAX_CONNECTIONS (%d)",
+			l.ReservedHigh+l.ReservedNormal, l.MaxConnections)
+	}
+}
+
+func (s *Shed) validate(v *validator, mode string) {
+	v.atLeast("SERVER_SHED_MAX_GOROUTINES", s.MaxGoroutines, 0)
+	v.atLeast("SERVER_SHED_MAX_HEAP_MB", s.MaxHeapMB, 0)
+	v.notNegative("SERVER_SHED_MAX_QUEUE_LATENCY_MS", s.MaxQueueLatency)
+	// the latency is of the limiter queue, and a rejecting limiter has none
+	v.check(s.MaxQueueLatency == 0 || mode != LimiterModeReject, "SERVER_SHED_MAX_QUEUE_LATENCY_MS",
+		"needs SERVER_LIMITER_MODE %q or %q, there is no queue in %q", LimiterModeQueue, LimiterModePriority, mode)
+	v.notNegative("SERVER_SHED_RETRY_AFTER_MS", s.RetryAfter)
+}
+
+func (c *Crawler) validate(v *validator) {
+	v.atLeast("CRAWLER_MAX_URLS", c.MaxURLs, 1)
+	v.atLeast("CRAWLER_MAX_WORKERS", c.MaxWorkers, 1)
+	v.positive("CRAWLER_REQUEST_TIMEOUT_MS", c.RequestTimeout)
+	v.notNegative("CRAWLER_MAX_BATCH_TIMEOUT_MS", c.MaxBatchTimeout)
+	v.notNegative("CRAWLER_MAX_URL_TIMEOUT_MS", c.MaxURLTimeout)
+	v.atLeast("CRAWLER_POOL_SIZE", c.PoolSize, 0)
+	// a request could never use all of its workers
+	v.check(c.PoolSize == 0 || c.PoolSize >= c.MaxWorkers, "CRAWLER_POOL_SIZE",
+		"must not be less than CRAWLER_MAX_WORKERS (%d), got %d", c.MaxWorkers, c.PoolSize)
+	v.atLeast("CRAWLER_MAX_OUTBOUND", c.MaxOutbound, 0)
+	v.atLeast("CRAWLER_OUTBOUND_QUEUE_SIZE", c.OutboundQueueSize, 0)
+	if c.MaxOutbound > 0 {
+		v.positive("CRAWLER_OUTBOUND_QUEUE_TIMEOUT_MS", c.OutboundQueueTimeout)
+	}
+
+	v.notNegative("CRAWLER_HEDGE_DELAY_MS", c.Hedge.Delay)
+	v.between("CRAWLER_HEDGE_PERCENTILE", c.Hedge.Percentile, 0, 99)
+	v.between("CRAWLER_HEDGE_BUDGET_PERCENT", c.Hedge.BudgetPercent, 0, 100)
+
+	v.between("CRAWLER_BREAKER_FAILURE_RATE_PCT", c.Breaker.FailureRatePct, 0, 100)
+	if c.Breaker.FailureRatePct > 0 {
+		v.atLeast("CRAWLER_BREAKER_MIN_REQUESTS", c.Breaker.MinRequests, 1)
+		v.positive("CRAWLER_BREAKER_WINDOW_MS", c.Breaker.Window)
+		v.positive("CRAWLER_BREAKER_COOLDOWN_MS", c.Breaker.CoolDown)
+		v.atLeast("CRAWLER_BREAKER_HALF_OPEN_REQUESTS", c.Breaker.HalfOpenRequests, 1)
+	}
+
+	a := c.Adaptive
+	v.atLeast("CRAWLER_ADAPTIVE_MIN_WORKERS", a.MinWorkers, 1)
+	v.atLeast("CRAWLER_ADAPTIVE_MAX_WORKERS", a.MaxWorkers, 0)
+	v.check(a.MaxWorkers == 0 || a.MaxWorkers >= a.MinWorkers, "CRAWLER_ADAPTIVE_MAX_WORKERS",
+		"must not be less than CRAWLER_ADAPTIVE_MIN_WORKERS (%d), got %d", a.MinWorkers, a.MaxWorkers)
+	v.atLeast("CRAWLER_ADAPTIVE_HOST_MIN_WORKERS", a.HostMinWorkers, 1)
+	v.check(a.HostMaxWorkers >= a.HostMinWorkers, "CRAWLER_ADAPTIVE_HOST_MAX_WORKERS",
+		"must not be less than CRAWLER_ADAPTIVE_HOST_MIN_WORKERS (%d), got %d", a.HostMinWorkers, a.HostMaxWorkers)
+	v.check(a.LatencyTarget.Std() >= time.Millisecond, "CRAWLER_ADAPTIVE_LATENCY_TARGET_MS",
+		"must be at least 1ms, got %s", a.LatencyTarget)
+}
+
+func (cl *ClientLimits) validate() error {
+	if _, ok := cl.Tiers[DefaultTier]; !ok {
+		return fmt.Errorf("tier %q is required", DefaultTier)
+	}
+	for _, tiers := range []map[string]string{cl.APIKeys, cl.Subjects} {
+		for _, tier := range tiers {
+			if _, ok := cl.Tiers[tier]; !ok {
+				return fmt.Errorf("unknown tier %q", tier)
+			}
+		}
+	}
+	for name, tier := range cl.Tiers {
+		if tier.RPM < 0 || tier.Burst < 0 || tier.Concurrency < 0 {
+			return fmt.Errorf("tier %q: limits must not be negative", name)
+		}
+		priority := strings.ToLower(strings.TrimSpace(tier.Priority))
+		if priority != "" && !slices.Contains([]string{PriorityLow, PriorityNormal, PriorityHigh}, priority) {
+			return fmt.Errorf("tier %q: unknown priority %q", name, tier.Priority)
+		}
+	}
+	if err := checkNets(cl.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	if cl.MaxClients < 1 || cl.IdleTTL <= 0 {
+		return errors.New("max_clients and idle_ttl must be positive")
+	}
+	return nil
+}
+
+// checkNets checks a list of IPs and CIDR networks, such as the trusted proxies.
+func checkNets(values []string) error {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case strings.Contains(v, "/"):
+			if _, _, err := net.ParseCIDR(v); err != nil {
+				return err
+			}
+		case net.ParseIP(v) == nil:
+			return fmt.Errorf("invalid ip %q", v)
+		}
+	}
+	return nil
+}
@@ -1,5 +1,6 @@
 package logger
 
+// Logger is the printf-style logger of the earlier releases, see FromSlog.
 type Logger interface {
 	Print(args ...interface{})
 	Printf(template string, args ...interface{})
@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Keys of the fields shared by all components, so that the log pipeline can
+// rely on them.
+const (
+	KeyComponent  = "component"
+	KeyRequestID  = "request_id"
+	KeyURL        = "url"
+	KeyHost       = "host"
+	KeyDuration   = "duration"
+	KeyStatus     = "status"
+	KeyError      = "error"
+	KeyErrorClass = "error_class"
+)
+
+// New creates a structured logger writing to w in the format at the level,
+// e.g. "debug", "info", "warn" or "error". Attributes stored in the context
+// with WithAttrs are added to every record logged with that context.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Discard returns a logger that drops everything.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+type ctxAttrsKey struct{}
+
+// WithAttrs returns a context carrying attrs to be logged with every record
+// logged with the context, e.g. the request ID.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(ctxAttrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	merged = append(merged, prev...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, ctxAttrsKey{}, merged)
+}
+
+// contextHandler adds the attributes of the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(ctxAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Err is the error field of a record.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// adapter implements Logger on top of slog.
+type adapter struct {
+	l *slog.Logger
+}
+
+// FromSlog adapts a structured logger to the Logger interface. Print messages
+// are logged at the info level, Fatal ones at the error level before exiting.
+func FromSlog(l *slog.Logger) Logger {
+	return adapter{l: l}
+}
+
+func (a adapter) Print(args ...interface{}) {
+	a.l.Info(fmt.Sprint(args...))
+}
+
+func (a adapter) Printf(template string, args ...interface{}) {
+	a.l.Info(fmt.Sprintf(template, args...))
+}
+
+func (a adapter) Fatal(args ...interface{}) {
+	a.l.Error(fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+func (a adapter) Fatalf(template string, args ...interface{}) {
+	a.l.Error(fmt.Sprintf(template, args...))
+	os.Exit(1)
+}
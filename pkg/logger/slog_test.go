@@ -0,0 +1,43 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/apoldev/go-http/pkg/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.New(&buf, logger.FormatJSON, "warn")
+	require.NoError(t, err)
+
+	ctx := logger.WithAttrs(context.Background(), slog.String(logger.KeyRequestID, "abc"))
+	log.InfoContext(ctx, "dropped")
+	log.WarnContext(ctx, "fetch failed", slog.String(logger.KeyHost, "google.com"))
+
+	var rec map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	require.Equal(t, "WARN", rec["level"])
+	require.Equal(t, "fetch failed", rec["msg"])
+	require.Equal(t, "abc", rec[logger.KeyRequestID])
+	require.Equal(t, "google.com", rec[logger.KeyHost])
+
+	_, err = logger.New(&buf, "xml", "info")
+	require.Error(t, err)
+	_, err = logger.New(&buf, logger.FormatText, "loud")
+	require.Error(t, err)
+}
+
+func TestFromSlog(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.New(&buf, logger.FormatText, "info")
+	require.NoError(t, err)
+
+	logger.FromSlog(log).Printf("got %d urls", 3)
+	require.Contains(t, buf.String(), `level=INFO msg="got 3 urls"`)
+}